@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"time"
+)
+
+// Audited actions.
+const (
+	AuditActionImpersonate  = "user.impersonate"
+	AuditActionLockoutReset = "user.lockout_reset"
+)
+
+// AuditLog records a security relevant action of ActorId concerning SubjectId, Details is a JSON object.
+type AuditLog struct {
+	Id        int64          `db:"id"`
+	Action    string         `db:"action"`
+	ActorId   sql.NullInt64  `db:"actor_id"`
+	SubjectId sql.NullInt64  `db:"subject_id"`
+	IpAddress string         `db:"ip_address"`
+	UserAgent string         `db:"user_agent"`
+	Details   types.JSONText `db:"details"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+type AuditLogRepo struct {
+	db *sqlx.DB
+}
+
+func NewAuditLogRepo(db *sqlx.DB) AuditLogRepo {
+	return AuditLogRepo{db: db}
+}
+
+func (r AuditLogRepo) Insert(ctx context.Context, log AuditLog) error {
+	if len(log.Details) == 0 {
+		log.Details = types.JSONText("{}")
+	}
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO audit_logs (action, actor_id, subject_id, ip_address, user_agent, details)
+		VALUES (:action, :actor_id, :subject_id, :ip_address, :user_agent, :details);`, log)
+	if err != nil {
+		return fmt.Errorf("insert audit log: %w", err)
+	}
+	return nil
+}
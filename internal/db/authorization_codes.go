@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+type AuthorizationCode struct {
+	CodeHash            string         `db:"code_hash"`
+	ClientId            string         `db:"client_id"`
+	UserId              int64          `db:"user_id"`
+	RedirectURI         string         `db:"redirect_uri"`
+	Scope               string         `db:"scope"`
+	Nonce               string         `db:"nonce"`
+	CodeChallenge       string         `db:"code_challenge"`
+	CodeChallengeMethod string         `db:"code_challenge_method"`
+	AuthTime            time.Time      `db:"auth_time"`
+	Amr                 pq.StringArray `db:"amr"`
+	ExpiresAt           time.Time      `db:"expires_at"`
+	UsedAt              sql.NullTime   `db:"used_at"`
+	CreatedAt           time.Time      `db:"created_at"`
+}
+
+type AuthorizationCodeRepo struct {
+	db *sqlx.DB
+}
+
+func NewAuthorizationCodeRepo(db *sqlx.DB) AuthorizationCodeRepo {
+	return AuthorizationCodeRepo{db: db}
+}
+
+func (r AuthorizationCodeRepo) Insert(ctx context.Context, code AuthorizationCode) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO authorization_codes
+		(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, amr, expires_at)
+		VALUES (:code_hash, :client_id, :user_id, :redirect_uri, :scope, :nonce, :code_challenge, :code_challenge_method, :auth_time, :amr, :expires_at);`, code)
+	if err != nil {
+		return fmt.Errorf("insert authorization code: %w", err)
+	}
+	return nil
+}
+
+// Consume marks the unexpired code as used and returns it, so a code can be exchanged only once.
+// sql.ErrNoRows is returned for unknown, expired or already used codes.
+func (r AuthorizationCodeRepo) Consume(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	if err := r.db.GetContext(ctx, &code, `UPDATE authorization_codes SET used_at = now()
+		WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING *`, codeHash); err != nil {
+		return AuthorizationCode{}, fmt.Errorf("consume authorization code: %w", err)
+	}
+	return code, nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+type Client struct {
+	Id            string         `db:"id"`
+	Name          string         `db:"name"`
+	RedirectURIs  pq.StringArray `db:"redirect_uris"`
+	AllowedScopes pq.StringArray `db:"allowed_scopes"`
+	// SecretHash is the bcrypt hash of the secret of confidential clients, public clients have none.
+	SecretHash sql.NullString `db:"secret_hash"`
+	// AccessTokenSeconds overrides the access token lifetime of the client.
+	AccessTokenSeconds sql.NullInt64 `db:"access_token_seconds"`
+	CreatedAt          time.Time     `db:"created_at"`
+}
+
+func (c Client) IsConfidential() bool {
+	return c.SecretHash.Valid
+}
+
+type ClientRepo struct {
+	db *sqlx.DB
+}
+
+func NewClientRepo(db *sqlx.DB) ClientRepo {
+	return ClientRepo{db: db}
+}
+
+func (r ClientRepo) GetById(ctx context.Context, id string) (Client, error) {
+	var client Client
+	if err := r.db.GetContext(ctx, &client, "SELECT * FROM clients WHERE id = $1", id); err != nil {
+		return Client{}, fmt.Errorf("get client by id: %w", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Device code statuses, a pending code is approved or denied by the user who entered the user code.
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+type DeviceCode struct {
+	DeviceCodeHash  string         `db:"device_code_hash"`
+	UserCode        string         `db:"user_code"`
+	ClientId        string         `db:"client_id"`
+	Scope           string         `db:"scope"`
+	Status          string         `db:"status"`
+	UserId          sql.NullInt64  `db:"user_id"`
+	AuthTime        sql.NullTime   `db:"auth_time"`
+	Amr             pq.StringArray `db:"amr"`
+	IntervalSeconds int64          `db:"interval_seconds"`
+	LastPolledAt    sql.NullTime   `db:"last_polled_at"`
+	ExpiresAt       time.Time      `db:"expires_at"`
+	UsedAt          sql.NullTime   `db:"used_at"`
+	CreatedAt       time.Time      `db:"created_at"`
+}
+
+type DeviceCodeRepo struct {
+	db *sqlx.DB
+}
+
+func NewDeviceCodeRepo(db *sqlx.DB) DeviceCodeRepo {
+	return DeviceCodeRepo{db: db}
+}
+
+func (r DeviceCodeRepo) Insert(ctx context.Context, code DeviceCode) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO device_codes
+		(device_code_hash, user_code, client_id, scope, interval_seconds, expires_at)
+		VALUES (:device_code_hash, :user_code, :client_id, :scope, :interval_seconds, :expires_at);`, code)
+	if err != nil {
+		return fmt.Errorf("insert device code: %w", err)
+	}
+	return nil
+}
+
+// GetPendingByUserCode returns the unexpired code waiting for the user's decision.
+func (r DeviceCodeRepo) GetPendingByUserCode(ctx context.Context, userCode string) (DeviceCode, error) {
+	var code DeviceCode
+	if err := r.db.GetContext(ctx, &code, `SELECT * FROM device_codes
+		WHERE user_code = $1 AND status = $2 AND expires_at > now()`, userCode, DeviceCodePending); err != nil {
+		return DeviceCode{}, fmt.Errorf("get device code by user code: %w", err)
+	}
+	return code, nil
+}
+
+// Decide records the user's decision on a pending code, sql.ErrNoRows is returned
+// for unknown, expired or already decided codes.
+func (r DeviceCodeRepo) Decide(ctx context.Context, userCode, status string, userId int64, authTime time.Time, amr []string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE device_codes SET status = $2, user_id = $3, auth_time = $4, amr = $5
+		WHERE user_code = $1 AND status = $6 AND expires_at > now()`,
+		userCode, status, userId, authTime, pq.StringArray(amr), DeviceCodePending)
+	if err != nil {
+		return fmt.Errorf("decide device code: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("decide device code: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Poll records a poll of the token endpoint and returns the code as it was before it,
+// so the caller can tell whether the client polls faster than the interval.
+func (r DeviceCodeRepo) Poll(ctx context.Context, deviceCodeHash string) (DeviceCode, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return DeviceCode{}, fmt.Errorf("begin poll device code: %w", err)
+	}
+	defer tx.Rollback()
+
+	var code DeviceCode
+	if err := tx.GetContext(ctx, &code, `SELECT * FROM device_codes
+		WHERE device_code_hash = $1 AND used_at IS NULL FOR UPDATE`, deviceCodeHash); err != nil {
+		return DeviceCode{}, fmt.Errorf("get device code: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE device_codes SET last_polled_at = now() WHERE device_code_hash = $1", deviceCodeHash); err != nil {
+		return DeviceCode{}, fmt.Errorf("update device code poll time: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DeviceCode{}, fmt.Errorf("commit poll device code: %w", err)
+	}
+	return code, nil
+}
+
+// SlowDown increases the polling interval of the code by the given number of seconds.
+func (r DeviceCodeRepo) SlowDown(ctx context.Context, deviceCodeHash string, seconds int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE device_codes SET interval_seconds = interval_seconds + $2 WHERE device_code_hash = $1", deviceCodeHash, seconds)
+	if err != nil {
+		return fmt.Errorf("slow down device code: %w", err)
+	}
+	return nil
+}
+
+// Consume marks the approved, unexpired code as used and returns it, so a code can be exchanged only once.
+// sql.ErrNoRows is returned for unknown, expired, not approved or already used codes.
+func (r DeviceCodeRepo) Consume(ctx context.Context, deviceCodeHash string) (DeviceCode, error) {
+	var code DeviceCode
+	if err := r.db.GetContext(ctx, &code, `UPDATE device_codes SET used_at = now()
+		WHERE device_code_hash = $1 AND status = $2 AND used_at IS NULL AND expires_at > now()
+		RETURNING *`, deviceCodeHash, DeviceCodeApproved); err != nil {
+		return DeviceCode{}, fmt.Errorf("consume device code: %w", err)
+	}
+	return code, nil
+}
+
+// UserCodeAttempts counts the wrong device flow user codes a user entered since WindowStartedAt.
+type UserCodeAttempts struct {
+	FailedAttempts  int       `db:"failed_attempts"`
+	WindowStartedAt time.Time `db:"window_started_at"`
+}
+
+type UserCodeAttemptRepo struct {
+	db *sqlx.DB
+}
+
+func NewUserCodeAttemptRepo(db *sqlx.DB) UserCodeAttemptRepo {
+	return UserCodeAttemptRepo{db: db}
+}
+
+// Record counts a user code entry as failed before the code is looked up, so parallel entries can't
+// bypass the limit, a new window starts once the current one is over. Forgive takes it back for a valid code.
+func (r UserCodeAttemptRepo) Record(ctx context.Context, userId int64, window time.Duration) (UserCodeAttempts, error) {
+	var attempts UserCodeAttempts
+	if err := r.db.GetContext(ctx, &attempts, `INSERT INTO user_code_attempts (user_id, failed_attempts) VALUES ($1, 1)
+		ON CONFLICT (user_id) DO UPDATE SET
+			failed_attempts = CASE WHEN user_code_attempts.window_started_at <= now() - make_interval(secs => $2::float8)
+				THEN 1 ELSE user_code_attempts.failed_attempts + 1 END,
+			window_started_at = CASE WHEN user_code_attempts.window_started_at <= now() - make_interval(secs => $2::float8)
+				THEN now() ELSE user_code_attempts.window_started_at END
+		RETURNING failed_attempts, window_started_at`, userId, window.Seconds()); err != nil {
+		return UserCodeAttempts{}, fmt.Errorf("record user code attempt: %w", err)
+	}
+	return attempts, nil
+}
+
+// Forgive takes back the attempt recorded for a valid user code.
+func (r UserCodeAttemptRepo) Forgive(ctx context.Context, userId int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE user_code_attempts SET failed_attempts = greatest(failed_attempts - 1, 0)
+		WHERE user_id = $1`, userId)
+	if err != nil {
+		return fmt.Errorf("forgive user code attempt: %w", err)
+	}
+	return nil
+}
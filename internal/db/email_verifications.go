@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// EmailVerification is a link sent to Email, it verifies the email only while the user still has it.
+type EmailVerification struct {
+	Id        int64        `db:"id"`
+	UserId    int64        `db:"user_id"`
+	Email     string       `db:"email"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type EmailVerificationRepo struct {
+	db *sqlx.DB
+}
+
+func NewEmailVerificationRepo(db *sqlx.DB) EmailVerificationRepo {
+	return EmailVerificationRepo{db: db}
+}
+
+func (r EmailVerificationRepo) Insert(ctx context.Context, verification EmailVerification) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO email_verifications (user_id, email, token_hash, expires_at)
+		VALUES (:user_id, :email, :token_hash, :expires_at);`, verification)
+	if err != nil {
+		return fmt.Errorf("insert email verification: %w", err)
+	}
+	return nil
+}
+
+// ListSentSince returns when verifications were sent to the user after since, oldest first.
+func (r EmailVerificationRepo) ListSentSince(ctx context.Context, userId int64, since time.Time) ([]time.Time, error) {
+	sent := []time.Time{}
+	if err := r.db.SelectContext(ctx, &sent, `SELECT created_at FROM email_verifications
+		WHERE user_id = $1 AND created_at > $2 ORDER BY created_at`, userId, since); err != nil {
+		return nil, fmt.Errorf("list sent email verifications: %w", err)
+	}
+	return sent, nil
+}
+
+// Use marks the pending verification used and the user's email verified, it returns the user id.
+// sql.ErrNoRows is returned when the verification isn't pending anymore or the user has another email now.
+func (r EmailVerificationRepo) Use(ctx context.Context, hash string) (int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin use email verification: %w", err)
+	}
+	defer tx.Rollback()
+
+	var verification EmailVerification
+	if err := tx.GetContext(ctx, &verification, `UPDATE email_verifications SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING *`, hash); err != nil {
+		return 0, fmt.Errorf("mark email verification used: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE users SET email_verified_at = coalesce(email_verified_at, now())
+		WHERE id = $1 AND email = $2`, verification.UserId, verification.Email)
+	if err != nil {
+		return 0, fmt.Errorf("mark user email verified: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("mark user email verified: %w", err)
+	}
+	if affected == 0 {
+		return 0, fmt.Errorf("email changed since verification: %w", sql.ErrNoRows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit use email verification: %w", err)
+	}
+	return verification.UserId, nil
+}
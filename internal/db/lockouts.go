@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Lockout holds the sign in attempts in a row of a user or of an unknown login, the next attempt is refused
+// before NextAttemptAt and while LockedUntil lasts. UserId is zero and Email empty for unknown logins.
+type Lockout struct {
+	UserId         int64        `db:"user_id"`
+	Login          string       `db:"login"`
+	Email          string       `db:"email"`
+	FailedAttempts int          `db:"failed_attempts"`
+	NextAttemptAt  time.Time    `db:"next_attempt_at"`
+	LockedUntil    sql.NullTime `db:"locked_until"`
+	UpdatedAt      time.Time    `db:"updated_at"`
+}
+
+// LockoutKey is what a lockout belongs to, the user of a known login or the normalized login otherwise.
+type LockoutKey struct {
+	UserId int64
+	Login  string
+}
+
+// column returns the lockouts column matching the key and its value.
+func (k LockoutKey) column() (string, any) {
+	if k.UserId != 0 {
+		return "user_id", k.UserId
+	}
+	return "login", k.Login
+}
+
+type AccountUnlock struct {
+	Id        int64        `db:"id"`
+	UserId    int64        `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type LockoutRepo struct {
+	db *sqlx.DB
+}
+
+func NewLockoutRepo(db *sqlx.DB) LockoutRepo {
+	return LockoutRepo{db: db}
+}
+
+const lockoutColumns = `COALESCE(l.user_id, 0) AS user_id, COALESCE(u.login, l.login) AS login,
+	COALESCE(u.email, '') AS email, l.failed_attempts, l.next_attempt_at, l.locked_until, l.updated_at
+	FROM lockouts l
+	LEFT JOIN users u ON u.id = l.user_id`
+
+func (r LockoutRepo) GetByKey(ctx context.Context, key LockoutKey) (Lockout, error) {
+	column, value := key.column()
+	var lockout Lockout
+	if err := r.db.GetContext(ctx, &lockout, "SELECT "+lockoutColumns+" WHERE l."+column+" = $1", value); err != nil {
+		return Lockout{}, fmt.Errorf("get lockout: %w", err)
+	}
+	return lockout, nil
+}
+
+// ListActive returns the users with failed sign ins in a row or locked out, the latest failures first.
+func (r LockoutRepo) ListActive(ctx context.Context) ([]Lockout, error) {
+	lockouts := []Lockout{}
+	if err := r.db.SelectContext(ctx, &lockouts, "SELECT "+lockoutColumns+`
+		WHERE l.user_id IS NOT NULL AND (l.failed_attempts > 0 OR l.locked_until > now())
+		ORDER BY l.updated_at DESC`); err != nil {
+		return nil, fmt.Errorf("list lockouts: %w", err)
+	}
+	return lockouts, nil
+}
+
+// lockoutWait is the SQL delay after the attempt in a row numbered by attempts, in seconds $2 doubles with every
+// attempt up to $3, the attempt reaching the threshold $4 waits $3.
+func lockoutWait(attempts string) string {
+	return fmt.Sprintf(`make_interval(secs => CASE WHEN %[1]s >= $4::int THEN $3::float8
+		ELSE least($2::float8 * power(2, least(%[1]s - 1, 30)), $3::float8) END)`, attempts)
+}
+
+// Reserve counts a sign in attempt of the key before the password is checked and delays the next one as if it
+// failed, by backoff doubling with every attempt in a row and by lock from the threshold attempt on.
+// It returns the attempts in a row, sql.ErrNoRows when the attempt is too early or the key is locked.
+// Parallel attempts can't all pass, the check and the delay are a single statement.
+func (r LockoutRepo) Reserve(ctx context.Context, key LockoutKey, backoff, lock time.Duration, threshold int) (int, error) {
+	column, value := key.column()
+	var attempts int
+	if err := r.db.GetContext(ctx, &attempts, fmt.Sprintf(`INSERT INTO lockouts AS l (%[1]s, failed_attempts, next_attempt_at)
+		VALUES ($1, 1, now() + %[2]s)
+		ON CONFLICT (%[1]s) DO UPDATE SET failed_attempts = l.failed_attempts + 1,
+			next_attempt_at = now() + %[3]s, updated_at = now()
+		WHERE l.next_attempt_at <= now() AND (l.locked_until IS NULL OR l.locked_until <= now())
+		RETURNING l.failed_attempts`, column, lockoutWait("1"), lockoutWait("(l.failed_attempts + 1)")),
+		value, backoff.Seconds(), lock.Seconds(), threshold); err != nil {
+		return 0, fmt.Errorf("reserve sign in attempt: %w", err)
+	}
+	return attempts, nil
+}
+
+// Lock refuses sign ins of the key until lockedUntil, the attempts count again from zero afterward.
+func (r LockoutRepo) Lock(ctx context.Context, key LockoutKey, lockedUntil time.Time) error {
+	column, value := key.column()
+	_, err := r.db.ExecContext(ctx, `UPDATE lockouts SET failed_attempts = 0, locked_until = $2, updated_at = now()
+		WHERE `+column+` = $1`, value, lockedUntil)
+	if err != nil {
+		return fmt.Errorf("lock sign ins: %w", err)
+	}
+	return nil
+}
+
+// Reset clears the failed sign ins and the lock of the user, sql.ErrNoRows is returned when there are none.
+func (r LockoutRepo) Reset(ctx context.Context, userId int64) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM lockouts WHERE user_id = $1", userId)
+	if err != nil {
+		return fmt.Errorf("reset lockout: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("reset lockout: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteStale deletes the lockouts without attempts after before which neither delay nor lock sign ins anymore.
+func (r LockoutRepo) DeleteStale(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM lockouts WHERE updated_at < $1
+		AND next_attempt_at <= now() AND (locked_until IS NULL OR locked_until <= now())`, before)
+	if err != nil {
+		return fmt.Errorf("delete stale lockouts: %w", err)
+	}
+	return nil
+}
+
+type SignInAttemptRepo struct {
+	db *sqlx.DB
+}
+
+func NewSignInAttemptRepo(db *sqlx.DB) SignInAttemptRepo {
+	return SignInAttemptRepo{db: db}
+}
+
+// Insert records a sign in attempt before its password is checked and returns its id, userId is null for unknown logins.
+func (r SignInAttemptRepo) Insert(ctx context.Context, login string, userId sql.NullInt64, ipAddress string) (int64, error) {
+	var id int64
+	if err := r.db.GetContext(ctx, &id, `INSERT INTO sign_in_attempts (login, user_id, ip_address) VALUES ($1, $2, $3)
+		RETURNING id`, login, userId, ipAddress); err != nil {
+		return 0, fmt.Errorf("insert sign in attempt: %w", err)
+	}
+	return id, nil
+}
+
+// Delete forgets the attempt, the attempts left are the failed sign ins.
+func (r SignInAttemptRepo) Delete(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM sign_in_attempts WHERE id = $1", id); err != nil {
+		return fmt.Errorf("delete sign in attempt: %w", err)
+	}
+	return nil
+}
+
+// CountLoginsByIp returns how many different logins were attempted from the IP address after since,
+// the attempt exceptId left out.
+func (r SignInAttemptRepo) CountLoginsByIp(ctx context.Context, ipAddress string, since time.Time, exceptId int64) (int, error) {
+	var count int
+	if err := r.db.GetContext(ctx, &count, `SELECT count(DISTINCT login) FROM sign_in_attempts
+		WHERE ip_address = $1 AND created_at > $2 AND id <> $3`, ipAddress, since, exceptId); err != nil {
+		return 0, fmt.Errorf("count sign in attempts by ip: %w", err)
+	}
+	return count, nil
+}
+
+func (r SignInAttemptRepo) DeleteBefore(ctx context.Context, before time.Time) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM sign_in_attempts WHERE created_at < $1", before)
+	if err != nil {
+		return fmt.Errorf("delete sign in attempts: %w", err)
+	}
+	return nil
+}
+
+type AccountUnlockRepo struct {
+	db *sqlx.DB
+}
+
+func NewAccountUnlockRepo(db *sqlx.DB) AccountUnlockRepo {
+	return AccountUnlockRepo{db: db}
+}
+
+func (r AccountUnlockRepo) Insert(ctx context.Context, unlock AccountUnlock) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO account_unlocks (user_id, token_hash, expires_at)
+		VALUES (:user_id, :token_hash, :expires_at);`, unlock)
+	if err != nil {
+		return fmt.Errorf("insert account unlock: %w", err)
+	}
+	return nil
+}
+
+// Use marks the pending unlock used and clears the lockout of the user, it returns the user id.
+// sql.ErrNoRows is returned when the unlock isn't pending anymore.
+func (r AccountUnlockRepo) Use(ctx context.Context, hash string) (int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin use account unlock: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userId int64
+	if err := tx.GetContext(ctx, &userId, `UPDATE account_unlocks SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, hash); err != nil {
+		return 0, fmt.Errorf("mark account unlock used: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM lockouts WHERE user_id = $1", userId); err != nil {
+		return 0, fmt.Errorf("reset lockout: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit use account unlock: %w", err)
+	}
+	return userId, nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// OutboxMail is a message waiting for delivery, NextAttemptAt is null once it is sent or given up on.
+type OutboxMail struct {
+	Id            int64        `db:"id"`
+	Recipient     string       `db:"recipient"`
+	Subject       string       `db:"subject"`
+	TextBody      string       `db:"text_body"`
+	HtmlBody      string       `db:"html_body"`
+	Attempts      int          `db:"attempts"`
+	LastError     string       `db:"last_error"`
+	NextAttemptAt sql.NullTime `db:"next_attempt_at"`
+	SentAt        sql.NullTime `db:"sent_at"`
+	CreatedAt     time.Time    `db:"created_at"`
+}
+
+type MailOutboxRepo struct {
+	db *sqlx.DB
+}
+
+func NewMailOutboxRepo(db *sqlx.DB) MailOutboxRepo {
+	return MailOutboxRepo{db: db}
+}
+
+func (r MailOutboxRepo) Insert(ctx context.Context, mail OutboxMail) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO mail_outbox (recipient, subject, text_body, html_body)
+		VALUES (:recipient, :subject, :text_body, :html_body);`, mail)
+	if err != nil {
+		return fmt.Errorf("insert outbox mail: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue returns up to limit mails due for an attempt, counting it. They aren't due again for lease,
+// so other instances don't send them meanwhile and a crashed attempt is retried.
+func (r MailOutboxRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]OutboxMail, error) {
+	mails := []OutboxMail{}
+	if err := r.db.SelectContext(ctx, &mails, `UPDATE mail_outbox
+		SET attempts = attempts + 1, next_attempt_at = now() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM mail_outbox WHERE next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, limit, lease.Seconds()); err != nil {
+		return nil, fmt.Errorf("claim due outbox mails: %w", err)
+	}
+	return mails, nil
+}
+
+func (r MailOutboxRepo) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mail_outbox SET sent_at = now(), next_attempt_at = NULL WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("mark outbox mail sent: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records the failed attempt, a null nextAttemptAt gives up on the mail.
+func (r MailOutboxRepo) MarkFailed(ctx context.Context, id int64, lastError string, nextAttemptAt sql.NullTime) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mail_outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1",
+		id, lastError, nextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("mark outbox mail failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN token_generation bigint not null default 0;
+
+CREATE TABLE revoked_tokens
+(
+    jti        text primary key,
+    expires_at timestamptz not null,
+    revoked_at timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE revoked_tokens;
+
+ALTER TABLE users
+    DROP COLUMN token_generation;
+-- +goose StatementEnd
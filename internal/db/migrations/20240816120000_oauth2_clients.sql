@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE clients
+(
+    id             text primary key,
+    name           text        not null,
+    redirect_uris  text[]      not null default '{}',
+    allowed_scopes text[]      not null default '{}',
+    created_at     timestamptz not null default now()
+);
+
+CREATE TABLE authorization_codes
+(
+    code_hash             text primary key,
+    client_id             text        not null references clients (id) on delete cascade,
+    user_id               bigint      not null references users (id) on delete cascade,
+    redirect_uri          text        not null,
+    scope                 text        not null,
+    nonce                 text        not null,
+    code_challenge        text        not null,
+    code_challenge_method text        not null,
+    auth_time             timestamptz not null,
+    amr                   text[]      not null default '{}',
+    expires_at            timestamptz not null,
+    used_at               timestamptz,
+    created_at            timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE authorization_codes;
+DROP TABLE clients;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE clients
+    ADD COLUMN secret_hash          text,
+    ADD COLUMN access_token_seconds integer;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE clients
+    DROP COLUMN secret_hash,
+    DROP COLUMN access_token_seconds;
+-- +goose StatementEnd
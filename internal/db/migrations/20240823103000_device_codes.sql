@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE device_codes
+(
+    device_code_hash text primary key,
+    user_code        text        not null unique,
+    client_id        text        not null references clients (id) on delete cascade,
+    scope            text        not null,
+    status           text        not null default 'pending',
+    user_id          bigint references users (id) on delete cascade,
+    auth_time        timestamptz,
+    amr              text[]      not null default '{}',
+    interval_seconds bigint      not null,
+    last_polled_at   timestamptz,
+    expires_at       timestamptz not null,
+    used_at          timestamptz,
+    created_at       timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE device_codes;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE personal_access_tokens
+(
+    id           bigserial primary key,
+    user_id      bigint      not null references users (id) on delete cascade,
+    name         text        not null,
+    token_hash   text        not null unique,
+    token_prefix text        not null,
+    scope        text        not null,
+    expires_at   timestamptz,
+    last_used_at timestamptz,
+    revoked_at   timestamptz,
+    created_at   timestamptz not null default now()
+);
+
+CREATE INDEX personal_access_tokens_user_id_idx ON personal_access_tokens (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE personal_access_tokens;
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE roles
+(
+    id          bigserial primary key,
+    name        text        not null unique,
+    description text        not null default '',
+    created_at  timestamptz not null default now()
+);
+
+CREATE TABLE permissions
+(
+    id          bigserial primary key,
+    name        text not null unique,
+    description text not null default ''
+);
+
+CREATE TABLE role_permissions
+(
+    role_id       bigint not null references roles (id) on delete cascade,
+    permission_id bigint not null references permissions (id) on delete cascade,
+    primary key (role_id, permission_id)
+);
+
+CREATE TABLE user_roles
+(
+    user_id    bigint      not null references users (id) on delete cascade,
+    role_id    bigint      not null references roles (id) on delete cascade,
+    created_at timestamptz not null default now(),
+    primary key (user_id, role_id)
+);
+
+INSERT INTO roles (name, description)
+VALUES ('admin', 'Manages users and their roles');
+
+INSERT INTO permissions (name, description)
+VALUES ('roles:manage', 'Assign and remove roles of users');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r,
+     permissions p
+WHERE r.name = 'admin';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_roles;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
+-- +goose StatementEnd
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE organizations
+(
+    id         bigserial primary key,
+    name       text        not null,
+    created_at timestamptz not null default now()
+);
+
+CREATE TABLE memberships
+(
+    organization_id bigint      not null references organizations (id) on delete cascade,
+    user_id         bigint      not null references users (id) on delete cascade,
+    role            text        not null,
+    created_at      timestamptz not null default now(),
+    primary key (organization_id, user_id)
+);
+
+CREATE INDEX memberships_user_id_idx ON memberships (user_id);
+
+CREATE TABLE invitations
+(
+    id              bigserial primary key,
+    organization_id bigint      not null references organizations (id) on delete cascade,
+    email           text        not null,
+    role            text        not null,
+    token_hash      text        not null unique,
+    invited_by      bigint      references users (id) on delete set null,
+    expires_at      timestamptz not null,
+    accepted_at     timestamptz,
+    declined_at     timestamptz,
+    created_at      timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE invitations;
+DROP TABLE memberships;
+DROP TABLE organizations;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sessions
+(
+    id           text primary key,
+    user_id      bigint      not null references users (id) on delete cascade,
+    user_agent   text        not null,
+    ip_address   text        not null,
+    expires_at   timestamptz not null,
+    last_used_at timestamptz not null default now(),
+    revoked_at   timestamptz,
+    created_at   timestamptz not null default now()
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE sessions;
+-- +goose StatementEnd
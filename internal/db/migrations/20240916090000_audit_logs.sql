@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE audit_logs
+(
+    id         bigserial primary key,
+    action     text        not null,
+    actor_id   bigint      references users (id) on delete set null,
+    subject_id bigint      references users (id) on delete set null,
+    ip_address text        not null default '',
+    user_agent text        not null default '',
+    details    jsonb       not null default '{}',
+    created_at timestamptz not null default now()
+);
+
+CREATE INDEX audit_logs_actor_id_idx ON audit_logs (actor_id);
+CREATE INDEX audit_logs_subject_id_idx ON audit_logs (subject_id);
+
+INSERT INTO permissions (name, description)
+VALUES ('users:impersonate', 'Act as another user through short-lived tokens');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r,
+     permissions p
+WHERE r.name = 'admin'
+  AND p.name = 'users:impersonate';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users:impersonate';
+DROP TABLE audit_logs;
+-- +goose StatementEnd
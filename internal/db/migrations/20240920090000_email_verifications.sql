@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN email_verified_at timestamptz;
+
+CREATE TABLE email_verifications
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users (id) on delete cascade,
+    email      text        not null,
+    token_hash text        not null unique,
+    expires_at timestamptz not null,
+    used_at    timestamptz,
+    created_at timestamptz not null default now()
+);
+
+CREATE INDEX email_verifications_user_id_created_at_idx ON email_verifications (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE email_verifications;
+
+ALTER TABLE users DROP COLUMN email_verified_at;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE mail_outbox
+(
+    id              bigserial primary key,
+    recipient       text        not null,
+    subject         text        not null,
+    text_body       text        not null,
+    html_body       text        not null,
+    attempts        integer     not null default 0,
+    last_error      text        not null default '',
+    -- null once sent or given up on
+    next_attempt_at timestamptz          default now(),
+    sent_at         timestamptz,
+    created_at      timestamptz not null default now()
+);
+
+CREATE INDEX mail_outbox_next_attempt_at_idx ON mail_outbox (next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE mail_outbox;
+-- +goose StatementEnd
@@ -0,0 +1,59 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sign_in_attempts
+(
+    id         bigserial primary key,
+    login      text        not null,
+    user_id    bigint references users (id) on delete cascade,
+    ip_address text        not null,
+    created_at timestamptz not null default now()
+);
+
+CREATE INDEX sign_in_attempts_ip_address_created_at_idx ON sign_in_attempts (ip_address, created_at);
+CREATE INDEX sign_in_attempts_created_at_idx ON sign_in_attempts (created_at);
+
+-- A lockout belongs to a user, or to a login no user has so refusals don't tell which accounts exist.
+CREATE TABLE lockouts
+(
+    id              bigserial primary key,
+    user_id         bigint unique references users (id) on delete cascade,
+    login           text unique,
+    failed_attempts integer     not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    locked_until    timestamptz,
+    updated_at      timestamptz not null default now(),
+    CHECK ((user_id IS NULL) <> (login IS NULL))
+);
+
+CREATE INDEX lockouts_updated_at_idx ON lockouts (updated_at);
+
+CREATE TABLE account_unlocks
+(
+    id         bigserial primary key,
+    user_id    bigint      not null references users (id) on delete cascade,
+    token_hash text        not null unique,
+    expires_at timestamptz not null,
+    used_at    timestamptz,
+    created_at timestamptz not null default now()
+);
+
+CREATE INDEX account_unlocks_user_id_idx ON account_unlocks (user_id);
+
+INSERT INTO permissions (name, description)
+VALUES ('users:unlock', 'List sign in lockouts and unlock users');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id
+FROM roles r,
+     permissions p
+WHERE r.name = 'admin'
+  AND p.name = 'users:unlock';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users:unlock';
+DROP TABLE account_unlocks;
+DROP TABLE lockouts;
+DROP TABLE sign_in_attempts;
+-- +goose StatementEnd
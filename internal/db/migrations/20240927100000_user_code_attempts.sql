@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE user_code_attempts
+(
+    user_id           bigint primary key references users (id) on delete cascade,
+    failed_attempts   integer     not null default 0,
+    window_started_at timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE user_code_attempts;
+-- +goose StatementEnd
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Roles of a user within an organization.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+type Organization struct {
+	Id        int64     `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type Membership struct {
+	OrganizationId   int64     `db:"organization_id"`
+	OrganizationName string    `db:"organization_name"`
+	UserId           int64     `db:"user_id"`
+	Login            string    `db:"login"`
+	Email            string    `db:"email"`
+	Role             string    `db:"role"`
+	CreatedAt        time.Time `db:"created_at"`
+}
+
+type Invitation struct {
+	Id             int64         `db:"id"`
+	OrganizationId int64         `db:"organization_id"`
+	Email          string        `db:"email"`
+	Role           string        `db:"role"`
+	TokenHash      string        `db:"token_hash"`
+	InvitedBy      sql.NullInt64 `db:"invited_by"`
+	ExpiresAt      time.Time     `db:"expires_at"`
+	AcceptedAt     sql.NullTime  `db:"accepted_at"`
+	DeclinedAt     sql.NullTime  `db:"declined_at"`
+	CreatedAt      time.Time     `db:"created_at"`
+}
+
+type OrganizationRepo struct {
+	db *sqlx.DB
+}
+
+func NewOrganizationRepo(db *sqlx.DB) OrganizationRepo {
+	return OrganizationRepo{db: db}
+}
+
+// Create stores the organization with the user as its owner.
+func (r OrganizationRepo) Create(ctx context.Context, name string, ownerId int64) (Organization, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Organization{}, fmt.Errorf("begin create organization: %w", err)
+	}
+	defer tx.Rollback()
+
+	var organization Organization
+	if err := tx.GetContext(ctx, &organization, "INSERT INTO organizations (name) VALUES ($1) RETURNING *", name); err != nil {
+		return Organization{}, fmt.Errorf("insert organization: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)",
+		organization.Id, ownerId, OrgRoleOwner); err != nil {
+		return Organization{}, fmt.Errorf("insert owner membership: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Organization{}, fmt.Errorf("commit create organization: %w", err)
+	}
+	return organization, nil
+}
+
+func (r OrganizationRepo) GetById(ctx context.Context, id int64) (Organization, error) {
+	var organization Organization
+	if err := r.db.GetContext(ctx, &organization, "SELECT * FROM organizations WHERE id = $1", id); err != nil {
+		return Organization{}, fmt.Errorf("get organization by id: %w", err)
+	}
+	return organization, nil
+}
+
+const membershipColumns = `m.organization_id, o.name AS organization_name, m.user_id, u.login, u.email, m.role, m.created_at
+	FROM memberships m
+	JOIN organizations o ON o.id = m.organization_id
+	JOIN users u ON u.id = m.user_id`
+
+// ListByUser returns the memberships of the user in every organization.
+func (r OrganizationRepo) ListByUser(ctx context.Context, userId int64) ([]Membership, error) {
+	memberships := []Membership{}
+	if err := r.db.SelectContext(ctx, &memberships, "SELECT "+membershipColumns+`
+		WHERE m.user_id = $1 ORDER BY o.name, m.organization_id`, userId); err != nil {
+		return nil, fmt.Errorf("list user memberships: %w", err)
+	}
+	return memberships, nil
+}
+
+// ListMembers returns the memberships of every user of the organization.
+func (r OrganizationRepo) ListMembers(ctx context.Context, organizationId int64) ([]Membership, error) {
+	memberships := []Membership{}
+	if err := r.db.SelectContext(ctx, &memberships, "SELECT "+membershipColumns+`
+		WHERE m.organization_id = $1 ORDER BY m.created_at, m.user_id`, organizationId); err != nil {
+		return nil, fmt.Errorf("list organization members: %w", err)
+	}
+	return memberships, nil
+}
+
+// GetMemberRole returns the role of the user in the organization, sql.ErrNoRows when the user isn't a member.
+func (r OrganizationRepo) GetMemberRole(ctx context.Context, organizationId, userId int64) (string, error) {
+	var role string
+	if err := r.db.GetContext(ctx, &role, "SELECT role FROM memberships WHERE organization_id = $1 AND user_id = $2",
+		organizationId, userId); err != nil {
+		return "", fmt.Errorf("get member role: %w", err)
+	}
+	return role, nil
+}
+
+type InvitationRepo struct {
+	db *sqlx.DB
+}
+
+func NewInvitationRepo(db *sqlx.DB) InvitationRepo {
+	return InvitationRepo{db: db}
+}
+
+func (r InvitationRepo) Insert(ctx context.Context, invitation Invitation) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO invitations
+		(organization_id, email, role, token_hash, invited_by, expires_at)
+		VALUES (:organization_id, :email, :role, :token_hash, :invited_by, :expires_at);`, invitation)
+	if err != nil {
+		return fmt.Errorf("insert invitation: %w", err)
+	}
+	return nil
+}
+
+// GetPendingByHash returns the unexpired invitation which is neither accepted nor declined.
+func (r InvitationRepo) GetPendingByHash(ctx context.Context, hash string) (Invitation, error) {
+	var invitation Invitation
+	if err := r.db.GetContext(ctx, &invitation, `SELECT * FROM invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > now()`, hash); err != nil {
+		return Invitation{}, fmt.Errorf("get invitation by hash: %w", err)
+	}
+	return invitation, nil
+}
+
+// Accept marks the pending invitation accepted and makes the user a member with the invited role.
+// An existing membership keeps its role. sql.ErrNoRows is returned when the invitation isn't pending anymore.
+func (r InvitationRepo) Accept(ctx context.Context, id, userId int64) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin accept invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var invitation Invitation
+	if err := tx.GetContext(ctx, &invitation, `UPDATE invitations SET accepted_at = now()
+		WHERE id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > now()
+		RETURNING *`, id); err != nil {
+		return fmt.Errorf("mark invitation accepted: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, invitation.OrganizationId, userId, invitation.Role); err != nil {
+		return fmt.Errorf("insert membership: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit accept invitation: %w", err)
+	}
+	return nil
+}
+
+// Decline marks the pending invitation declined, sql.ErrNoRows is returned when it isn't pending anymore.
+func (r InvitationRepo) Decline(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE invitations SET declined_at = now()
+		WHERE id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > now()`, id)
+	if err != nil {
+		return fmt.Errorf("decline invitation: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("decline invitation: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type PasswordReset struct {
+	Id        int64        `db:"id"`
+	UserId    int64        `db:"user_id"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type PasswordResetRepo struct {
+	db *sqlx.DB
+}
+
+func NewPasswordResetRepo(db *sqlx.DB) PasswordResetRepo {
+	return PasswordResetRepo{db: db}
+}
+
+func (r PasswordResetRepo) Insert(ctx context.Context, reset PasswordReset) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO password_resets (user_id, token_hash, expires_at)
+		VALUES (:user_id, :token_hash, :expires_at);`, reset)
+	if err != nil {
+		return fmt.Errorf("insert password reset: %w", err)
+	}
+	return nil
+}
+
+// GetPendingByHash returns the unexpired and unused password reset.
+func (r PasswordResetRepo) GetPendingByHash(ctx context.Context, hash string) (PasswordReset, error) {
+	var reset PasswordReset
+	if err := r.db.GetContext(ctx, &reset, `SELECT * FROM password_resets
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()`, hash); err != nil {
+		return PasswordReset{}, fmt.Errorf("get password reset by hash: %w", err)
+	}
+	return reset, nil
+}
+
+// Use marks the pending password reset and every other pending one of the user used and sets the user's password.
+// sql.ErrNoRows is returned when the reset isn't pending anymore.
+func (r PasswordResetRepo) Use(ctx context.Context, id int64, password string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin use password reset: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userId int64
+	if err := tx.GetContext(ctx, &userId, `UPDATE password_resets SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, id); err != nil {
+		return fmt.Errorf("mark password reset used: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL",
+		userId); err != nil {
+		return fmt.Errorf("mark other password resets used: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = $2 WHERE id = $1", userId, password); err != nil {
+		return fmt.Errorf("update user password: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit use password reset: %w", err)
+	}
+	return nil
+}
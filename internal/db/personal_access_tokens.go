@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+type PersonalAccessToken struct {
+	Id        int64  `db:"id"`
+	UserId    int64  `db:"user_id"`
+	Name      string `db:"name"`
+	TokenHash string `db:"token_hash"`
+	// TokenPrefix is the beginning of the token, it is shown in listings to recognize the token.
+	TokenPrefix string       `db:"token_prefix"`
+	Scope       string       `db:"scope"`
+	ExpiresAt   sql.NullTime `db:"expires_at"`
+	LastUsedAt  sql.NullTime `db:"last_used_at"`
+	RevokedAt   sql.NullTime `db:"revoked_at"`
+	CreatedAt   time.Time    `db:"created_at"`
+}
+
+type PersonalAccessTokenRepo struct {
+	db *sqlx.DB
+}
+
+func NewPersonalAccessTokenRepo(db *sqlx.DB) PersonalAccessTokenRepo {
+	return PersonalAccessTokenRepo{db: db}
+}
+
+// Insert stores the token and returns it with the generated id and creation time.
+func (r PersonalAccessTokenRepo) Insert(ctx context.Context, token PersonalAccessToken) (PersonalAccessToken, error) {
+	stmt, err := r.db.PrepareNamedContext(ctx, `INSERT INTO personal_access_tokens
+		(user_id, name, token_hash, token_prefix, scope, expires_at)
+		VALUES (:user_id, :name, :token_hash, :token_prefix, :scope, :expires_at)
+		RETURNING *;`)
+	if err != nil {
+		return PersonalAccessToken{}, fmt.Errorf("prepare insert personal access token: %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted PersonalAccessToken
+	if err := stmt.GetContext(ctx, &inserted, token); err != nil {
+		return PersonalAccessToken{}, fmt.Errorf("insert personal access token: %w", err)
+	}
+	return inserted, nil
+}
+
+// ListByUser returns the tokens of the user which are not revoked, newest first.
+func (r PersonalAccessTokenRepo) ListByUser(ctx context.Context, userId int64) ([]PersonalAccessToken, error) {
+	tokens := []PersonalAccessToken{}
+	if err := r.db.SelectContext(ctx, &tokens, `SELECT * FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC, id DESC`, userId); err != nil {
+		return nil, fmt.Errorf("list personal access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// GetActiveByHash returns the token if it is neither revoked nor expired.
+func (r PersonalAccessTokenRepo) GetActiveByHash(ctx context.Context, hash string) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	if err := r.db.GetContext(ctx, &token, `SELECT * FROM personal_access_tokens
+		WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`, hash); err != nil {
+		return PersonalAccessToken{}, fmt.Errorf("get personal access token by hash: %w", err)
+	}
+	return token, nil
+}
+
+// Touch records the token use, last_used_at is updated at most once a minute to spare writes.
+func (r PersonalAccessTokenRepo) Touch(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE personal_access_tokens SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`, id)
+	if err != nil {
+		return fmt.Errorf("touch personal access token: %w", err)
+	}
+	return nil
+}
+
+// Revoke revokes the token of the user, sql.ErrNoRows is returned for unknown or already revoked tokens.
+func (r PersonalAccessTokenRepo) Revoke(ctx context.Context, userId, id int64) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE personal_access_tokens SET revoked_at = now()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userId)
+	if err != nil {
+		return fmt.Errorf("revoke personal access token: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke personal access token: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeByUser revokes every token of the user.
+func (r PersonalAccessTokenRepo) RevokeByUser(ctx context.Context, userId int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE personal_access_tokens SET revoked_at = now()
+		WHERE user_id = $1 AND revoked_at IS NULL`, userId)
+	if err != nil {
+		return fmt.Errorf("revoke personal access tokens by user: %w", err)
+	}
+	return nil
+}
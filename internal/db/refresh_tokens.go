@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// ErrRefreshTokenUsed is returned when a refresh token was already rotated or revoked.
+var ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+type RefreshToken struct {
+	Id        string       `db:"id"`
+	FamilyId  string       `db:"family_id"`
+	Subject   string       `db:"subject"`
+	TokenHash string       `db:"token_hash"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+	CreatedAt time.Time    `db:"created_at"`
+}
+
+type RefreshTokenRepo struct {
+	db *sqlx.DB
+}
+
+func NewRefreshTokenRepo(db *sqlx.DB) RefreshTokenRepo {
+	return RefreshTokenRepo{db: db}
+}
+
+func (r RefreshTokenRepo) Insert(ctx context.Context, token RefreshToken) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO refresh_tokens (id, family_id, subject, token_hash, expires_at)
+		VALUES (:id, :family_id, :subject, :token_hash, :expires_at);`, token)
+	if err != nil {
+		return fmt.Errorf("insert refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r RefreshTokenRepo) GetByHash(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	if err := r.db.GetContext(ctx, &token, "SELECT * FROM refresh_tokens WHERE token_hash = $1", hash); err != nil {
+		return RefreshToken{}, fmt.Errorf("get refresh token by hash: %w", err)
+	}
+	return token, nil
+}
+
+// Rotate marks the used token as consumed and stores its successor in one transaction.
+// ErrRefreshTokenUsed is returned when the token was consumed concurrently.
+func (r RefreshTokenRepo) Rotate(ctx context.Context, usedId string, next RefreshToken) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin rotate refresh token: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL`, usedId)
+	if err != nil {
+		return fmt.Errorf("mark refresh token used: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("mark refresh token used: %w", err)
+	}
+	if affected == 0 {
+		return ErrRefreshTokenUsed
+	}
+
+	if _, err := tx.NamedExecContext(ctx, `INSERT INTO refresh_tokens (id, family_id, subject, token_hash, expires_at)
+		VALUES (:id, :family_id, :subject, :token_hash, :expires_at);`, next); err != nil {
+		return fmt.Errorf("insert rotated refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit rotate refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r RefreshTokenRepo) RevokeFamily(ctx context.Context, familyId string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL", familyId)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+func (r RefreshTokenRepo) RevokeBySubject(ctx context.Context, subject string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = now() WHERE subject = $1 AND revoked_at IS NULL", subject)
+	if err != nil {
+		return fmt.Errorf("revoke refresh tokens by subject: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+type RevokedTokenRepo struct {
+	db *sqlx.DB
+}
+
+func NewRevokedTokenRepo(db *sqlx.DB) RevokedTokenRepo {
+	return RevokedTokenRepo{db: db}
+}
+
+// Revoke stores the token id until the token expires on its own.
+// Entries of already expired tokens are cleaned up on the way.
+func (r RevokedTokenRepo) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING", jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()"); err != nil {
+		return fmt.Errorf("delete expired revoked tokens: %w", err)
+	}
+	return nil
+}
+
+// IsRevoked reports whether any of the ids is revoked, a token is checked together with its session.
+func (r RevokedTokenRepo) IsRevoked(ctx context.Context, ids ...string) (bool, error) {
+	var revoked bool
+	if err := r.db.GetContext(ctx, &revoked, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = ANY($1))", pq.Array(ids)); err != nil {
+		return false, fmt.Errorf("check revoked token: %w", err)
+	}
+	return revoked, nil
+}
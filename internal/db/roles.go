@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"time"
+)
+
+// RoleAdmin is created by the migrations with every permission below.
+const RoleAdmin = "admin"
+
+const (
+	PermissionRolesManage      = "roles:manage"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionUsersUnlock      = "users:unlock"
+)
+
+type Role struct {
+	Id          int64          `db:"id"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Permissions pq.StringArray `db:"permissions"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+type RoleRepo struct {
+	db *sqlx.DB
+}
+
+func NewRoleRepo(db *sqlx.DB) RoleRepo {
+	return RoleRepo{db: db}
+}
+
+// List returns every role with the names of its permissions.
+func (r RoleRepo) List(ctx context.Context) ([]Role, error) {
+	roles := []Role{}
+	if err := r.db.SelectContext(ctx, &roles, `SELECT r.id, r.name, r.description, r.created_at,
+			COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}') AS permissions
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id
+		ORDER BY r.name`); err != nil {
+		return nil, fmt.Errorf("list roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GetUserRoles returns the role names of the user.
+func (r RoleRepo) GetUserRoles(ctx context.Context, userId int64) ([]string, error) {
+	roles := []string{}
+	if err := r.db.SelectContext(ctx, &roles, `SELECT r.name FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = $1
+		ORDER BY r.name`, userId); err != nil {
+		return nil, fmt.Errorf("get user roles: %w", err)
+	}
+	return roles, nil
+}
+
+// GetUserPermissions returns the permission names granted to the user through any of their roles.
+func (r RoleRepo) GetUserPermissions(ctx context.Context, userId int64) ([]string, error) {
+	permissions := []string{}
+	if err := r.db.SelectContext(ctx, &permissions, `SELECT DISTINCT p.name FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+		ORDER BY p.name`, userId); err != nil {
+		return nil, fmt.Errorf("get user permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// Assign gives the role to the user, assigning a role twice is not an error.
+// sql.ErrNoRows is returned when the user or the role doesn't exist.
+func (r RoleRepo) Assign(ctx context.Context, userId int64, role string) error {
+	var assigned bool
+	if err := r.db.GetContext(ctx, &assigned, `WITH inserted AS (
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT u.id, r.id FROM users u, roles r WHERE u.id = $1 AND r.name = $2
+			ON CONFLICT DO NOTHING
+			RETURNING 1
+		)
+		SELECT EXISTS(SELECT 1 FROM inserted) OR EXISTS(
+			SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = $1 AND r.name = $2
+		)`, userId, role); err != nil {
+		return fmt.Errorf("assign role: %w", err)
+	}
+	if !assigned {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Unassign takes the role from the user, sql.ErrNoRows is returned when the user doesn't have it.
+func (r RoleRepo) Unassign(ctx context.Context, userId int64, role string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_roles
+		WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`, userId, role)
+	if err != nil {
+		return fmt.Errorf("unassign role: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("unassign role: %w", err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// BootstrapAdmin gives the admin role to the user while nobody has it yet, users who haven't verified
+// their email are skipped. It reports whether the role was assigned.
+func (r RoleRepo) BootstrapAdmin(ctx context.Context, userId int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `INSERT INTO user_roles (user_id, role_id)
+		SELECT u.id, r.id FROM users u, roles r
+		WHERE u.id = $1 AND u.email_verified_at IS NOT NULL AND r.name = $2
+			AND NOT EXISTS (SELECT 1 FROM user_roles ur WHERE ur.role_id = r.id)
+		ON CONFLICT DO NOTHING`, userId, RoleAdmin)
+	if err != nil {
+		return false, fmt.Errorf("bootstrap admin: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("bootstrap admin: %w", err)
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"time"
+)
+
+// Session is a sign in on one device, its id is the family id of the refresh tokens issued to it.
+type Session struct {
+	Id         string       `db:"id"`
+	UserId     int64        `db:"user_id"`
+	UserAgent  string       `db:"user_agent"`
+	IpAddress  string       `db:"ip_address"`
+	ExpiresAt  time.Time    `db:"expires_at"`
+	LastUsedAt time.Time    `db:"last_used_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+	CreatedAt  time.Time    `db:"created_at"`
+}
+
+type SessionRepo struct {
+	db *sqlx.DB
+}
+
+func NewSessionRepo(db *sqlx.DB) SessionRepo {
+	return SessionRepo{db: db}
+}
+
+func (r SessionRepo) Insert(ctx context.Context, session Session) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO sessions (id, user_id, user_agent, ip_address, expires_at)
+		VALUES (:id, :user_id, :user_agent, :ip_address, :expires_at);`, session)
+	if err != nil {
+		return fmt.Errorf("insert session: %w", err)
+	}
+	return nil
+}
+
+func (r SessionRepo) GetById(ctx context.Context, id string) (Session, error) {
+	var session Session
+	if err := r.db.GetContext(ctx, &session, "SELECT * FROM sessions WHERE id = $1", id); err != nil {
+		return Session{}, fmt.Errorf("get session by id: %w", err)
+	}
+	return session, nil
+}
+
+// ListActiveByUser returns the sessions of the user which are neither revoked nor expired, last used first.
+func (r SessionRepo) ListActiveByUser(ctx context.Context, userId int64) ([]Session, error) {
+	sessions := []Session{}
+	if err := r.db.SelectContext(ctx, &sessions, `SELECT * FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_used_at DESC, created_at DESC`, userId); err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Touch records a refresh of the session, which then lasts until expiresAt.
+func (r SessionRepo) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET last_used_at = now(), expires_at = $2 WHERE id = $1", id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("touch session: %w", err)
+	}
+	return nil
+}
+
+func (r SessionRepo) Revoke(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+func (r SessionRepo) RevokeByUser(ctx context.Context, userId int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userId)
+	if err != nil {
+		return fmt.Errorf("revoke sessions by user: %w", err)
+	}
+	return nil
+}
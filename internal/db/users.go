@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 )
@@ -11,6 +12,10 @@ type User struct {
 	Login    string `db:"login"`
 	Email    string `db:"email"`
 	Password string `db:"password"`
+	// EmailVerifiedAt is set once the user proved owning Email.
+	EmailVerifiedAt sql.NullTime `db:"email_verified_at"`
+
+	TokenGeneration int64 `db:"token_generation"`
 }
 
 type UserRepo struct {
@@ -37,6 +42,14 @@ func (u UserRepo) GetByLogin(ctx context.Context, login string) (User, error) {
 	return user, nil
 }
 
+func (u UserRepo) GetByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	if err := u.db.GetContext(ctx, &user, "SELECT * FROM users WHERE email = $1", email); err != nil {
+		return User{}, fmt.Errorf("get user by email: %w", err)
+	}
+	return user, nil
+}
+
 func (u UserRepo) GetByLoginOrEmail(ctx context.Context, login, email string) (User, error) {
 	var user User
 	if err := u.db.GetContext(ctx, &user, "SELECT * FROM users WHERE login = $1 OR email = $2", login, email); err != nil {
@@ -45,10 +58,42 @@ func (u UserRepo) GetByLoginOrEmail(ctx context.Context, login, email string) (U
 	return user, nil
 }
 
-func (u UserRepo) Insert(ctx context.Context, user User) error {
-	_, err := u.db.NamedExecContext(ctx, "INSERT INTO users (login, email, password) VALUES (:login, :email, :password);", user)
+func (u UserRepo) Insert(ctx context.Context, user User) (int64, error) {
+	stmt, err := u.db.PrepareNamedContext(ctx, `INSERT INTO users (login, email, password, email_verified_at)
+		VALUES (:login, :email, :password, :email_verified_at) RETURNING id;`)
+	if err != nil {
+		return 0, fmt.Errorf("prepare insert user: %w", err)
+	}
+	defer stmt.Close()
+
+	var id int64
+	if err := stmt.GetContext(ctx, &id, user); err != nil {
+		return 0, fmt.Errorf("insert user: %w", err)
+	}
+	return id, nil
+}
+
+func (u UserRepo) GetTokenGeneration(ctx context.Context, id int64) (int64, error) {
+	var generation int64
+	if err := u.db.GetContext(ctx, &generation, "SELECT token_generation FROM users WHERE id = $1", id); err != nil {
+		return 0, fmt.Errorf("get user token generation: %w", err)
+	}
+	return generation, nil
+}
+
+func (u UserRepo) UpdatePassword(ctx context.Context, id int64, password string) error {
+	_, err := u.db.ExecContext(ctx, "UPDATE users SET password = $2 WHERE id = $1", id, password)
+	if err != nil {
+		return fmt.Errorf("update user password: %w", err)
+	}
+	return nil
+}
+
+// IncrementTokenGeneration invalidates every token issued to the user before the call.
+func (u UserRepo) IncrementTokenGeneration(ctx context.Context, id int64) error {
+	_, err := u.db.ExecContext(ctx, "UPDATE users SET token_generation = token_generation + 1 WHERE id = $1", id)
 	if err != nil {
-		return fmt.Errorf("insert user: %w", err)
+		return fmt.Errorf("increment user token generation: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,104 @@
+package hashing
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const (
+	argon2idPrefix     = "$argon2id$"
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+	// Shorter salts and keys of stored hashes are rejected, an empty key would match any password.
+	argon2idMinSaltLength = 8
+	argon2idMinKeyLength  = 16
+)
+
+// Argon2id hashes in the PHC string format: $argon2id$v=19$m=65536,t=3,p=4$salt$key.
+type Argon2id struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func NewArgon2id(memory, iterations uint32, parallelism uint8) (Argon2id, error) {
+	if memory < 8*uint32(parallelism) || iterations == 0 || parallelism == 0 {
+		return Argon2id{}, fmt.Errorf("argon2id parameters m=%d,t=%d,p=%d not valid", memory, iterations, parallelism)
+	}
+	return Argon2id{
+		memory:      memory,
+		iterations:  iterations,
+		parallelism: parallelism,
+	}, nil
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("read random salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, a.iterations, a.memory, a.parallelism, argon2idKeyLength)
+	return a.encode(salt, key), nil
+}
+
+func (a Argon2id) Verify(encoded, password string) (bool, error) {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return false, ErrUnsupportedHash
+	}
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, actual) == 1, nil
+}
+
+func (a Argon2id) NeedsRehash(encoded string) bool {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return true
+	}
+	params, _, _, err := decodeArgon2id(encoded)
+	return err != nil || params != a
+}
+
+func (a Argon2id) MaxPasswordBytes() int {
+	return 0
+}
+
+func (a Argon2id) encode(salt, key []byte) string {
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.memory, a.iterations, a.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(encoded string) (Argon2id, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id hash malformed")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id version %q not supported", parts[2])
+	}
+	var params Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id parameters malformed: %w", err)
+	}
+	// argon2.IDKey panics on zero iterations or parallelism.
+	if _, err := NewArgon2id(params.memory, params.iterations, params.parallelism); err != nil {
+		return Argon2id{}, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2idMinSaltLength {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id salt malformed")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2idMinKeyLength {
+		return Argon2id{}, nil, nil, fmt.Errorf("argon2id key malformed")
+	}
+	return params, salt, key, nil
+}
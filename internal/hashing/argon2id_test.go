@@ -0,0 +1,137 @@
+package hashing
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestArgon2id(t *testing.T, memory, iterations uint32, parallelism uint8) Argon2id {
+	t.Helper()
+	a, err := NewArgon2id(memory, iterations, parallelism)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestNewArgon2id(t *testing.T) {
+	tests := []struct {
+		name        string
+		memory      uint32
+		iterations  uint32
+		parallelism uint8
+		wantErr     bool
+	}{
+		{name: "valid", memory: 64, iterations: 1, parallelism: 1},
+		{name: "memory below 8 KiB per lane", memory: 31, iterations: 1, parallelism: 4, wantErr: true},
+		{name: "no iterations", memory: 64, iterations: 0, parallelism: 1, wantErr: true},
+		{name: "no parallelism", memory: 64, iterations: 1, parallelism: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewArgon2id(tt.memory, tt.iterations, tt.parallelism)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	a := newTestArgon2id(t, 64, 1, 1)
+	for _, password := range []string{"correct horse battery staple", "", "pässwörd 🔑", strings.Repeat("a", 200)} {
+		encoded, err := a.Hash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+			t.Errorf("encoded %s", encoded)
+		}
+		if ok, err := a.Verify(encoded, password); err != nil || !ok {
+			t.Errorf("Verify(%q) = %v, %v", password, ok, err)
+		}
+		if ok, err := a.Verify(encoded, password+"x"); err != nil || ok {
+			t.Errorf("Verify of another password = %v, %v", ok, err)
+		}
+		if a.NeedsRehash(encoded) {
+			t.Error("fresh hash needs a rehash")
+		}
+	}
+
+	first, _ := a.Hash("password")
+	second, _ := a.Hash("password")
+	if first == second {
+		t.Error("hashes are not salted")
+	}
+}
+
+func TestArgon2idTamperedHash(t *testing.T) {
+	a := newTestArgon2id(t, 64, 1, 1)
+	encoded, err := a.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(encoded, "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{name: "memory changed", encoded: "$argon2id$v=19$m=128,t=1,p=1$" + salt + "$" + key},
+		{name: "iterations changed", encoded: "$argon2id$v=19$m=64,t=2,p=1$" + salt + "$" + key},
+		{name: "parallelism changed", encoded: "$argon2id$v=19$m=64,t=1,p=2$" + salt + "$" + key},
+		{name: "salt changed", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + strings.Repeat("A", len(salt)) + "$" + key},
+		{name: "key truncated", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key[:len(key)-4]},
+		{name: "empty key", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$", wantErr: true},
+		{name: "short key", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key[:8], wantErr: true},
+		{name: "empty salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$$" + key, wantErr: true},
+		{name: "zero iterations", encoded: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key, wantErr: true},
+		{name: "zero parallelism", encoded: "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key, wantErr: true},
+		{name: "parallelism overflow", encoded: "$argon2id$v=19$m=64,t=1,p=257$" + salt + "$" + key, wantErr: true},
+		{name: "other version", encoded: "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key, wantErr: true},
+		{name: "parameters missing", encoded: "$argon2id$v=19$" + salt + "$" + key, wantErr: true},
+		{name: "salt not base64", encoded: "$argon2id$v=19$m=64,t=1,p=1$!!!!!!!!!!!$" + key, wantErr: true},
+		{name: "key not base64", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!!!!!!!!!!!!!!!!!!!!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := a.Verify(tt.encoded, "password")
+			if ok {
+				t.Error("tampered hash verified")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !a.NeedsRehash(tt.encoded) {
+				t.Error("malformed hash doesn't need a rehash")
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	current := newTestArgon2id(t, 64, 1, 1)
+	tests := []struct {
+		name   string
+		hasher Argon2id
+		want   bool
+	}{
+		{name: "same parameters", hasher: current},
+		{name: "more memory", hasher: newTestArgon2id(t, 128, 1, 1), want: true},
+		{name: "more iterations", hasher: newTestArgon2id(t, 64, 2, 1), want: true},
+		{name: "more parallelism", hasher: newTestArgon2id(t, 64, 1, 2), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.hasher.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := current.NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
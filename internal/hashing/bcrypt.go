@@ -0,0 +1,61 @@
+package hashing
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// bcryptMaxPasswordBytes is where bcrypt stops reading the password, longer ones are refused by Hash.
+const bcryptMaxPasswordBytes = 72
+
+// Bcrypt hashes in the modular crypt format, $2a$ followed by the cost.
+type Bcrypt struct {
+	cost int
+}
+
+func NewBcrypt(cost int) (Bcrypt, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return Bcrypt{}, fmt.Errorf("bcrypt cost %d out of range", cost)
+	}
+	return Bcrypt{cost: cost}, nil
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hash: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Verify(encoded, password string) (bool, error) {
+	if !isBcrypt(encoded) {
+		return false, ErrUnsupportedHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("bcrypt verify: %w", err)
+	}
+	return true, nil
+}
+
+func (b Bcrypt) NeedsRehash(encoded string) bool {
+	if !isBcrypt(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.cost
+}
+
+func (b Bcrypt) MaxPasswordBytes() int {
+	return bcryptMaxPasswordBytes
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
@@ -0,0 +1,124 @@
+package hashing
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func newTestBcrypt(t *testing.T, cost int) Bcrypt {
+	t.Helper()
+	b, err := NewBcrypt(cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestNewBcrypt(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    int
+		wantErr bool
+	}{
+		{name: "min cost", cost: bcrypt.MinCost},
+		{name: "max cost", cost: bcrypt.MaxCost},
+		{name: "below min cost", cost: bcrypt.MinCost - 1, wantErr: true},
+		{name: "above max cost", cost: bcrypt.MaxCost + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewBcrypt(tt.cost)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBcryptRoundTrip(t *testing.T) {
+	b := newTestBcrypt(t, bcrypt.MinCost)
+	for _, password := range []string{"correct horse battery staple", "", "pässwörd 🔑", strings.Repeat("a", 72)} {
+		encoded, err := b.Hash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := b.Verify(encoded, password); err != nil || !ok {
+			t.Errorf("Verify(%q) = %v, %v", password, ok, err)
+		}
+		if ok, err := b.Verify(encoded, "x"+password); err != nil || ok {
+			t.Errorf("Verify of another password = %v, %v", ok, err)
+		}
+		if b.NeedsRehash(encoded) {
+			t.Error("fresh hash needs a rehash")
+		}
+	}
+}
+
+func TestBcryptRejectsPasswordsOver72Bytes(t *testing.T) {
+	b := newTestBcrypt(t, bcrypt.MinCost)
+	if _, err := b.Hash(strings.Repeat("a", 73)); err == nil {
+		t.Error("hashed a password over 72 bytes")
+	}
+	if _, err := b.Hash(strings.Repeat("é", 37)); err == nil {
+		t.Error("hashed 37 two-byte runes")
+	}
+}
+
+func TestBcryptTamperedHash(t *testing.T) {
+	b := newTestBcrypt(t, bcrypt.MinCost)
+	encoded, err := b.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{name: "cost changed", encoded: "$2a$05" + encoded[6:]},
+		{name: "salt changed", encoded: encoded[:7] + strings.Repeat("a", 22) + encoded[29:]},
+		{name: "hash changed", encoded: encoded[:len(encoded)-4] + "aaaa"},
+		{name: "truncated", encoded: encoded[:40], wantErr: true},
+		{name: "cost out of range", encoded: "$2a$99" + encoded[6:], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.encoded == encoded {
+				t.Fatal("hash not changed")
+			}
+			ok, err := b.Verify(tt.encoded, "password")
+			if ok {
+				t.Error("tampered hash verified")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	current := newTestBcrypt(t, 5)
+	tests := []struct {
+		name string
+		cost int
+		want bool
+	}{
+		{name: "same cost", cost: 5},
+		{name: "lower cost", cost: 4, want: true},
+		{name: "higher cost", cost: 6, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := newTestBcrypt(t, tt.cost).Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := current.NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package hashing
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedHash is returned for encoded hashes of an algorithm the hasher doesn't handle.
+var ErrUnsupportedHash = errors.New("unsupported password hash")
+
+// Algorithms new passwords can be hashed with.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+type Config struct {
+	// PasswordHashAlgorithm hashes new passwords, hashes of the other algorithm are still verified
+	// and upgraded on the next sign in.
+	PasswordHashAlgorithm string `env:"PASSWORD_HASH_ALGORITHM, default=argon2id"`
+	PasswordBcryptCost    int    `env:"PASSWORD_BCRYPT_COST, default=12"`
+	// Argon2id parameters, memory is in KiB.
+	PasswordArgon2Memory      uint32 `env:"PASSWORD_ARGON2_MEMORY, default=65536"`
+	PasswordArgon2Iterations  uint32 `env:"PASSWORD_ARGON2_ITERATIONS, default=3"`
+	PasswordArgon2Parallelism uint8  `env:"PASSWORD_ARGON2_PARALLELISM, default=4"`
+}
+
+// PasswordHasher hashes passwords into self-describing encoded hashes, which name their algorithm and parameters.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the encoded hash, ErrUnsupportedHash is returned for other algorithms.
+	Verify(encoded, password string) (bool, error)
+	// NeedsRehash reports whether the encoded hash differs from what Hash produces now.
+	NeedsRehash(encoded string) bool
+	// MaxPasswordBytes is the longest password in bytes Hash accepts, 0 when there is no limit.
+	MaxPasswordBytes() int
+}
+
+// Hasher hashes with the configured algorithm and verifies hashes of every supported one.
+type Hasher struct {
+	current PasswordHasher
+	all     []PasswordHasher
+}
+
+func NewHasher(config Config) (Hasher, error) {
+	bcrypt, err := NewBcrypt(config.PasswordBcryptCost)
+	if err != nil {
+		return Hasher{}, err
+	}
+	argon2id, err := NewArgon2id(config.PasswordArgon2Memory, config.PasswordArgon2Iterations, config.PasswordArgon2Parallelism)
+	if err != nil {
+		return Hasher{}, err
+	}
+
+	var current PasswordHasher
+	switch config.PasswordHashAlgorithm {
+	case AlgorithmBcrypt:
+		current = bcrypt
+	case AlgorithmArgon2id:
+		current = argon2id
+	default:
+		return Hasher{}, fmt.Errorf("unsupported password hash algorithm %q", config.PasswordHashAlgorithm)
+	}
+	return Hasher{
+		current: current,
+		all:     []PasswordHasher{argon2id, bcrypt},
+	}, nil
+}
+
+func (h Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+func (h Hasher) Verify(encoded, password string) (bool, error) {
+	for _, hasher := range h.all {
+		ok, err := hasher.Verify(encoded, password)
+		if errors.Is(err, ErrUnsupportedHash) {
+			continue
+		}
+		return ok, err
+	}
+	return false, ErrUnsupportedHash
+}
+
+func (h Hasher) NeedsRehash(encoded string) bool {
+	return h.current.NeedsRehash(encoded)
+}
+
+// MaxPasswordBytes is the limit of the configured algorithm, the password policy enforces it.
+func (h Hasher) MaxPasswordBytes() int {
+	return h.current.MaxPasswordBytes()
+}
@@ -0,0 +1,120 @@
+package hashing
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestHasher(t *testing.T, algorithm string) Hasher {
+	t.Helper()
+	h, err := NewHasher(Config{
+		PasswordHashAlgorithm:     algorithm,
+		PasswordBcryptCost:        4,
+		PasswordArgon2Memory:      64,
+		PasswordArgon2Iterations:  1,
+		PasswordArgon2Parallelism: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestNewHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "bcrypt", config: Config{PasswordHashAlgorithm: AlgorithmBcrypt, PasswordBcryptCost: 4, PasswordArgon2Memory: 64, PasswordArgon2Iterations: 1, PasswordArgon2Parallelism: 1}},
+		{name: "argon2id", config: Config{PasswordHashAlgorithm: AlgorithmArgon2id, PasswordBcryptCost: 4, PasswordArgon2Memory: 64, PasswordArgon2Iterations: 1, PasswordArgon2Parallelism: 1}},
+		{name: "unknown algorithm", config: Config{PasswordHashAlgorithm: "scrypt", PasswordBcryptCost: 4, PasswordArgon2Memory: 64, PasswordArgon2Iterations: 1, PasswordArgon2Parallelism: 1}, wantErr: true},
+		{name: "bcrypt cost out of range", config: Config{PasswordHashAlgorithm: AlgorithmArgon2id, PasswordBcryptCost: 99, PasswordArgon2Memory: 64, PasswordArgon2Iterations: 1, PasswordArgon2Parallelism: 1}, wantErr: true},
+		{name: "argon2id parameters not valid", config: Config{PasswordHashAlgorithm: AlgorithmBcrypt, PasswordBcryptCost: 4, PasswordArgon2Memory: 64}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHasher(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHasherAcrossAlgorithms(t *testing.T) {
+	bcryptHash, err := newTestBcrypt(t, 4).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2idHash, err := newTestArgon2id(t, 64, 1, 1).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBcryptCost, err := newTestBcrypt(t, 5).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		algorithm       string
+		encoded         string
+		password        string
+		wantValid       bool
+		wantErr         error
+		wantNeedsRehash bool
+	}{
+		{name: "argon2id verifies bcrypt", algorithm: AlgorithmArgon2id, encoded: bcryptHash, password: "password", wantValid: true, wantNeedsRehash: true},
+		{name: "argon2id rejects wrong bcrypt password", algorithm: AlgorithmArgon2id, encoded: bcryptHash, password: "wrong", wantNeedsRehash: true},
+		{name: "argon2id verifies argon2id", algorithm: AlgorithmArgon2id, encoded: argon2idHash, password: "password", wantValid: true},
+		{name: "bcrypt verifies argon2id", algorithm: AlgorithmBcrypt, encoded: argon2idHash, password: "password", wantValid: true, wantNeedsRehash: true},
+		{name: "bcrypt rejects wrong argon2id password", algorithm: AlgorithmBcrypt, encoded: argon2idHash, password: "wrong", wantNeedsRehash: true},
+		{name: "bcrypt verifies bcrypt", algorithm: AlgorithmBcrypt, encoded: bcryptHash, password: "password", wantValid: true},
+		{name: "bcrypt of another cost", algorithm: AlgorithmBcrypt, encoded: otherBcryptCost, password: "password", wantValid: true, wantNeedsRehash: true},
+		{name: "plain text", algorithm: AlgorithmArgon2id, encoded: "password", password: "password", wantErr: ErrUnsupportedHash, wantNeedsRehash: true},
+		{name: "empty hash", algorithm: AlgorithmBcrypt, encoded: "", password: "", wantErr: ErrUnsupportedHash, wantNeedsRehash: true},
+		{name: "other algorithm", algorithm: AlgorithmArgon2id, encoded: "$scrypt$ln=16,r=8,p=1$c2FsdA$a2V5", password: "password", wantErr: ErrUnsupportedHash, wantNeedsRehash: true},
+		{name: "argon2i", algorithm: AlgorithmArgon2id, encoded: "$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5", password: "password", wantErr: ErrUnsupportedHash, wantNeedsRehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHasher(t, tt.algorithm)
+			valid, err := h.Verify(tt.encoded, tt.password)
+			if valid != tt.wantValid {
+				t.Errorf("valid %v, want %v", valid, tt.wantValid)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err %v, want %v", err, tt.wantErr)
+			}
+			if got := h.NeedsRehash(tt.encoded); got != tt.wantNeedsRehash {
+				t.Errorf("NeedsRehash %v, want %v", got, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestHasherHashesWithCurrentAlgorithm(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{algorithm: AlgorithmBcrypt, prefix: "$2a$04$"},
+		{algorithm: AlgorithmArgon2id, prefix: "$argon2id$v=19$m=64,t=1,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			h := newTestHasher(t, tt.algorithm)
+			encoded, err := h.Hash("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(encoded) < len(tt.prefix) || encoded[:len(tt.prefix)] != tt.prefix {
+				t.Errorf("encoded %s, want prefix %s", encoded, tt.prefix)
+			}
+			if h.NeedsRehash(encoded) {
+				t.Error("fresh hash needs a rehash")
+			}
+		})
+	}
+}
@@ -1,105 +1,759 @@
 package jwt
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is presented again.
+	// The whole token family is revoked before it is returned.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+	// ErrTokenRevoked is returned for tokens revoked by logout.
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrWrongTokenUse is returned when a token of one type is presented in place of another.
+	ErrWrongTokenUse = errors.New("wrong token use")
+	// ErrTokenClientMismatch is returned when a client revokes a token issued to another client.
+	ErrTokenClientMismatch = errors.New("token issued to another client")
+)
+
+// TokenUse tells what a token was issued for, a token is accepted only where its use is expected.
+type TokenUse string
+
+const (
+	TokenUseAccess  TokenUse = "access"
+	TokenUseRefresh TokenUse = "refresh"
+	// TokenUseState protects the oauth2 redirect flow and is never a credential.
+	TokenUseState TokenUse = "state"
+	// TokenUseId is the OpenID Connect id_token, it is issued for clients and never accepted back.
+	TokenUseId TokenUse = "id"
+	// TokenUsePersonal marks claims of an opaque personal access token, it is accepted as an access token.
+	TokenUsePersonal TokenUse = "personal"
+)
+
+// SubjectType tells whether sub is a user id or, for service-to-service calls, a client id.
+type SubjectType string
+
+const (
+	SubjectTypeUser   SubjectType = "user"
+	SubjectTypeClient SubjectType = "client"
+)
+
+const stateTokenDuration = 10 * time.Minute
+
 type Config struct {
-	JwtSecretKey         string `env:"JWT_SECRET_KEY"`
-	JwtAccessTokenHours  int64  `env:"JWT_ACCESS_TOKEN_HOURS"  envDefault:"24"`
-	JwtRefreshTokenHours int64  `env:"JWT_REFRESH_TOKEN_HOURS" envDefault:"168"`
+	// JwtSigningMethod is one of HS256, RS256, ES256, EdDSA (or their 384/512 variants).
+	// HMAC methods sign with JwtSecretKey, the others with the PEM key at JwtPrivateKeyPath.
+	JwtSigningMethod  string `env:"JWT_SIGNING_METHOD, default=HS256"`
+	JwtSecretKey      string `env:"JWT_SECRET_KEY"`
+	JwtPrivateKeyPath string `env:"JWT_PRIVATE_KEY_PATH"`
+	// JwtKeyId is set as the kid header, defaults to the key thumbprint for asymmetric keys.
+	JwtKeyId string `env:"JWT_KEY_ID"`
+	// JwtPublicKeyPaths are PEM public keys still accepted for verification after a key rotation,
+	// RSA keys are verified with JwtSigningMethod.
+	JwtPublicKeyPaths []string `env:"JWT_PUBLIC_KEY_PATHS"`
+
+	// JwtIssuer and JwtAudience are set as iss and aud of every token and required on verification.
+	JwtIssuer   string `env:"JWT_ISSUER, default=http://localhost:4000"`
+	JwtAudience string `env:"JWT_AUDIENCE, default=goauth-boilerplate"`
+
+	// JwtScopes are api scopes tokens can be granted besides the OpenID Connect ones.
+	JwtScopes []string `env:"JWT_SCOPES"`
+	// JwtDefaultScopes are granted when a sign in requests no scope, they must be supported.
+	JwtDefaultScopes []string `env:"JWT_DEFAULT_SCOPES"`
+
+	JwtAccessTokenHours  int64 `env:"JWT_ACCESS_TOKEN_HOURS"  envDefault:"24"`
+	JwtRefreshTokenHours int64 `env:"JWT_REFRESH_TOKEN_HOURS" envDefault:"168"`
+	// JwtImpersonationMinutes is the lifetime of impersonation tokens, they can't be refreshed.
+	JwtImpersonationMinutes int64 `env:"JWT_IMPERSONATION_MINUTES, default=15"`
+	// JwtRestrictUnverifiedEmail grants users who haven't verified their email only ScopeUnverifiedEmail
+	// and the OpenID Connect scopes, without roles. Otherwise they sign in like everyone else.
+	JwtRestrictUnverifiedEmail bool `env:"JWT_RESTRICT_UNVERIFIED_EMAIL"`
 }
 
 type Tokens struct {
 	AccessToken  string
 	RefreshToken string
+	// IdToken is issued only when the openid scope was granted.
+	IdToken   string
+	Scope     []string
+	ExpiresIn time.Duration
+}
+
+// Claims of every issued token, sub is the db.User id or the db.Client id depending on SubjectType.
+type Claims struct {
+	TokenUse    TokenUse    `json:"token_use"`
+	SubjectType SubjectType `json:"sub_type,omitempty"`
+	// Generation is the user's token generation at issue time, tokens of older generations are revoked.
+	Generation int64 `json:"gen,omitempty"`
+	// Scope is the space separated list of granted scopes.
+	Scope    string           `json:"scope,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	Amr      []string         `json:"amr,omitempty"`
+	Nonce    string           `json:"nonce,omitempty"`
+	// ClientId is the oauth2 client the token was issued to, empty for first-party sign in.
+	ClientId string `json:"client_id,omitempty"`
+	// OrgId is the organization the token is scoped to and OrgRole the user's role in it.
+	OrgId   int64  `json:"org_id,omitempty"`
+	OrgRole string `json:"org_role,omitempty"`
+	// Roles and Permissions of the user at issue time, they are read again on every refresh.
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// SessionId is the refresh token family the token belongs to, revoking the family revokes its access tokens too.
+	SessionId string `json:"sid,omitempty"`
+	// Actor is set on impersonation tokens, it names the admin acting as the subject.
+	Actor *Actor `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+func (c Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// IsClient reports whether the token was issued to a service through the client credentials grant.
+func (c Claims) IsClient() bool {
+	return c.SubjectType == SubjectTypeClient
+}
+
+func (c Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+func (c Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
 }
 
+// IsPersonal reports whether the claims belong to a personal access token rather than a signed in session.
+func (c Claims) IsPersonal() bool {
+	return c.TokenUse == TokenUsePersonal
+}
+
+// IsImpersonated reports whether an admin acts as the user through the token, see Authorizer.Impersonate.
+func (c Claims) IsImpersonated() bool {
+	return c.Actor != nil
+}
+
+// UserId returns the user id the token was issued to.
+func (c Claims) UserId() (int64, error) {
+	if c.IsClient() {
+		return 0, fmt.Errorf("token issued to client %s", c.Subject)
+	}
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse subject: %w", err)
+	}
+	return id, nil
+}
+
+type (
+	refreshTokenStore interface {
+		Insert(ctx context.Context, token db.RefreshToken) error
+		GetByHash(ctx context.Context, hash string) (db.RefreshToken, error)
+		Rotate(ctx context.Context, usedId string, next db.RefreshToken) error
+		RevokeFamily(ctx context.Context, familyId string) error
+		RevokeBySubject(ctx context.Context, subject string) error
+	}
+	sessionStore interface {
+		Insert(ctx context.Context, session db.Session) error
+		GetById(ctx context.Context, id string) (db.Session, error)
+		ListActiveByUser(ctx context.Context, userId int64) ([]db.Session, error)
+		Touch(ctx context.Context, id string, expiresAt time.Time) error
+		Revoke(ctx context.Context, id string) error
+		RevokeByUser(ctx context.Context, userId int64) error
+	}
+	revokedTokenStore interface {
+		Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+		IsRevoked(ctx context.Context, ids ...string) (bool, error)
+	}
+	userStore interface {
+		GetById(ctx context.Context, id int64) (db.User, error)
+		GetTokenGeneration(ctx context.Context, userId int64) (int64, error)
+		IncrementTokenGeneration(ctx context.Context, userId int64) error
+	}
+	roleStore interface {
+		GetUserRoles(ctx context.Context, userId int64) ([]string, error)
+		GetUserPermissions(ctx context.Context, userId int64) ([]string, error)
+	}
+	membershipStore interface {
+		GetMemberRole(ctx context.Context, organizationId, userId int64) (string, error)
+	}
+	personalAccessTokenStore interface {
+		Insert(ctx context.Context, token db.PersonalAccessToken) (db.PersonalAccessToken, error)
+		GetActiveByHash(ctx context.Context, hash string) (db.PersonalAccessToken, error)
+		Touch(ctx context.Context, id int64) error
+		RevokeByUser(ctx context.Context, userId int64) error
+	}
+)
+
 type Authorizer struct {
-	secretKey       []byte
-	accessDuration  int64
-	refreshDuration int64
+	keys                  keySet
+	issuer                string
+	audience              string
+	accessDuration        time.Duration
+	refreshDuration       time.Duration
+	impersonationDuration time.Duration
+	restrictUnverified    bool
+	scopes                []string
+	defaultScopes         []string
+	refreshTokens         refreshTokenStore
+	sessions              sessionStore
+	revokedTokens         revokedTokenStore
+	users                 userStore
+	roles                 roleStore
+	memberships           membershipStore
+	personalAccessTokens  personalAccessTokenStore
 }
 
-func NewAuthorizer(config Config) Authorizer {
-	return Authorizer{
-		secretKey:       []byte(config.JwtSecretKey),
-		accessDuration:  config.JwtAccessTokenHours,
-		refreshDuration: config.JwtRefreshTokenHours,
+func NewAuthorizer(
+	config Config,
+	refreshTokens refreshTokenStore,
+	sessions sessionStore,
+	revokedTokens revokedTokenStore,
+	users userStore,
+	roles roleStore,
+	memberships membershipStore,
+	personalAccessTokens personalAccessTokenStore,
+) (Authorizer, error) {
+	keys, err := loadKeySet(config)
+	if err != nil {
+		return Authorizer{}, fmt.Errorf("load signing keys: %w", err)
 	}
+	scopes, defaultScopes, err := newScopes(config)
+	if err != nil {
+		return Authorizer{}, err
+	}
+	return Authorizer{
+		keys:                  keys,
+		issuer:                config.JwtIssuer,
+		audience:              config.JwtAudience,
+		accessDuration:        time.Hour * time.Duration(config.JwtAccessTokenHours),
+		refreshDuration:       time.Hour * time.Duration(config.JwtRefreshTokenHours),
+		impersonationDuration: time.Minute * time.Duration(config.JwtImpersonationMinutes),
+		restrictUnverified:    config.JwtRestrictUnverifiedEmail,
+		scopes:                scopes,
+		defaultScopes:         defaultScopes,
+		refreshTokens:         refreshTokens,
+		sessions:              sessions,
+		revokedTokens:         revokedTokens,
+		users:                 users,
+		roles:                 roles,
+		memberships:           memberships,
+		personalAccessTokens:  personalAccessTokens,
+	}, nil
 }
 
-func (a Authorizer) CreateTokens(username string) (Tokens, error) {
-	accessToken, err := a.createToken(username, a.accessDuration)
+// CreateTokens starts a session: it issues an access token and the first refresh token of a new token family,
+// plus an id token when the openid scope was granted. The family id is the session id.
+func (a Authorizer) CreateTokens(ctx context.Context, userId int64, auth Authentication) (Tokens, error) {
+	generation, err := a.users.GetTokenGeneration(ctx, userId)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("get token generation: %w", err)
+	}
+	tokens, refreshToken, err := a.createTokens(ctx, strconv.FormatInt(userId, 10), generation, uuid.NewString(), auth)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("create access token: %w", err)
+		return Tokens{}, err
+	}
+	if err := a.sessions.Insert(ctx, db.Session{
+		Id:        refreshToken.FamilyId,
+		UserId:    userId,
+		UserAgent: auth.UserAgent,
+		IpAddress: auth.IpAddress,
+		ExpiresAt: refreshToken.ExpiresAt,
+	}); err != nil {
+		return Tokens{}, fmt.Errorf("store session: %w", err)
+	}
+	if err := a.refreshTokens.Insert(ctx, refreshToken); err != nil {
+		return Tokens{}, fmt.Errorf("store refresh token: %w", err)
+	}
+	return tokens, nil
+}
+
+// CreateClientToken issues an access token to a service authenticated with client credentials.
+// There is no refresh token, a zero duration falls back to the default access token lifetime.
+func (a Authorizer) CreateClientToken(clientId string, scope []string, duration time.Duration) (Tokens, error) {
+	if duration <= 0 {
+		duration = a.accessDuration
 	}
-	refreshToken, err := a.createToken(username, a.refreshDuration)
+	claims := a.newClaims(TokenUseAccess, clientId, duration)
+	claims.SubjectType = SubjectTypeClient
+	claims.ClientId = clientId
+	claims.Scope = strings.Join(scope, " ")
+	claims.AuthTime = claims.IssuedAt
+	accessToken, err := a.createToken(claims)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("create refresh token: %w", err)
+		return Tokens{}, fmt.Errorf("create client access token: %w", err)
 	}
 	return Tokens{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken: accessToken,
+		Scope:       scope,
+		ExpiresIn:   duration,
 	}, nil
 }
 
-func (a Authorizer) ValidateAndUpdate(token string) (Tokens, error) {
-	ok, identity, err := a.verifyToken(token)
+// CreateStateToken issues a short-lived token for the oauth2 state parameter,
+// the requested scope and nonce are kept in it until the callback.
+func (a Authorizer) CreateStateToken(scope []string, nonce string) (string, error) {
+	claims := a.newClaims(TokenUseState, "", stateTokenDuration)
+	claims.Scope = strings.Join(scope, " ")
+	claims.Nonce = nonce
+	stateToken, err := a.createToken(claims)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("token verification: %w")
+		return "", fmt.Errorf("create state token: %w", err)
 	}
-	if !ok {
-		return Tokens{}, fmt.Errorf("token not verified")
+	return stateToken, nil
+}
+
+// ValidateAndUpdate rotates the refresh token: the presented token is consumed and a new
+// access/refresh pair of the same family is returned. Presenting a consumed token again
+// revokes the whole family. The token must belong to clientId, empty for first-party tokens.
+func (a Authorizer) ValidateAndUpdate(ctx context.Context, token, clientId string) (Tokens, error) {
+	claims, stored, err := a.verifyRefreshToken(ctx, token)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if claims.ClientId != clientId {
+		return Tokens{}, fmt.Errorf("refresh token issued to another client")
 	}
-	tokens, err := a.CreateTokens(identity)
+	if stored.UsedAt.Valid {
+		return Tokens{}, a.revokeReusedFamily(ctx, stored.FamilyId)
+	}
+	if err := a.checkGeneration(ctx, claims); err != nil {
+		return Tokens{}, err
+	}
+
+	tokens, next, err := a.createTokens(ctx, claims.Subject, claims.Generation, stored.FamilyId, Authentication{
+		Scope:    claims.Scopes(),
+		AuthTime: claims.AuthTime.Time,
+		Methods:  claims.Amr,
+		ClientId: claims.ClientId,
+		OrgId:    claims.OrgId,
+	})
 	if err != nil {
 		return Tokens{}, fmt.Errorf("tokens not updated: %w", err)
 	}
+	if err := a.refreshTokens.Rotate(ctx, stored.Id, next); err != nil {
+		if errors.Is(err, db.ErrRefreshTokenUsed) {
+			return Tokens{}, a.revokeReusedFamily(ctx, stored.FamilyId)
+		}
+		return Tokens{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if err := a.sessions.Touch(ctx, stored.FamilyId, next.ExpiresAt); err != nil {
+		return Tokens{}, fmt.Errorf("record session use: %w", err)
+	}
 	return tokens, nil
 }
 
-func (a Authorizer) Validate(token string) (bool, string, error) {
-	ok, identity, err := a.verifyToken(token)
+// JWKS returns the public keys tokens can be verified with.
+func (a Authorizer) JWKS() JWKS {
+	return a.keys.jwks()
+}
+
+// ValidateState verifies a token created by CreateStateToken.
+func (a Authorizer) ValidateState(token string) (Claims, error) {
+	claims, err := a.verifyToken(token, TokenUseState)
 	if err != nil {
-		return false, "", fmt.Errorf("token verification: %w")
+		return Claims{}, fmt.Errorf("token verification: %w", err)
 	}
-	return ok, identity, nil
+	return claims, nil
 }
 
-func (a Authorizer) createToken(username string, hours int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"username": username,
-			"exp":      time.Now().Add(time.Hour * time.Duration(hours)).Unix(),
-		})
+// ValidateAccess verifies a token presented as a bearer credential, including its server-side
+// revocation state. Personal access tokens are accepted as well, see Claims.IsPersonal.
+func (a Authorizer) ValidateAccess(ctx context.Context, token string) (Claims, error) {
+	if strings.HasPrefix(token, PersonalAccessTokenPrefix) {
+		return a.validatePersonalAccessToken(ctx, token)
+	}
 
-	tokenString, err := token.SignedString(a.secretKey)
+	claims, err := a.verifyToken(token, TokenUseAccess)
 	if err != nil {
-		return "", fmt.Errorf("token sign: %w", err)
+		return Claims{}, fmt.Errorf("token verification: %w", err)
 	}
-	return tokenString, nil
+	ids := []string{claims.ID}
+	if claims.SessionId != "" {
+		ids = append(ids, claims.SessionId)
+	}
+	revoked, err := a.revokedTokens.IsRevoked(ctx, ids...)
+	if err != nil {
+		return Claims{}, fmt.Errorf("check token revocation: %w", err)
+	}
+	if revoked {
+		return Claims{}, ErrTokenRevoked
+	}
+	if claims.IsClient() {
+		return claims, nil
+	}
+	if err := a.checkGeneration(ctx, claims); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
 }
 
-func (a Authorizer) verifyToken(tokenString string) (bool, string, error) {
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
-		return a.secretKey, nil
-	})
+// Introspect returns the claims of an active access or refresh token, taking the
+// server-side revocation state into account. hint is the RFC 7662 token_type_hint,
+// it only decides which token type is tried first.
+func (a Authorizer) Introspect(ctx context.Context, token, hint string) (Claims, error) {
+	validators := []func(ctx context.Context, token string) (Claims, error){a.ValidateAccess, a.validateRefresh}
+	if hint == "refresh_token" {
+		slices.Reverse(validators)
+	}
 
-	identityData, ok := claims["username"]
-	if !ok {
-		return false, "", fmt.Errorf("claim not found")
+	var errs []error
+	for _, validate := range validators {
+		claims, err := validate(ctx, token)
+		if err == nil {
+			return claims, nil
+		}
+		errs = append(errs, err)
 	}
-	identity, ok := identityData.(string)
-	if !ok {
-		return false, "", fmt.Errorf("claim invalid")
+	return Claims{}, errors.Join(errs...)
+}
 
+// Logout revokes the access token described by claims and, when given, the refresh token family.
+func (a Authorizer) Logout(ctx context.Context, claims Claims, refreshToken string) error {
+	if claims.IsPersonal() {
+		return fmt.Errorf("personal access tokens are revoked by id: %w", ErrWrongTokenUse)
 	}
+	if err := a.revokedTokens.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	refreshClaims, stored, err := a.verifyRefreshToken(ctx, refreshToken)
 	if err != nil {
-		return false, identity, fmt.Errorf("parse token: %w", err)
+		return err
+	}
+	if refreshClaims.Subject != claims.Subject {
+		return fmt.Errorf("refresh token subject mismatch")
+	}
+	return a.revokeFamily(ctx, stored.FamilyId)
+}
+
+// Revoke is the RFC 7009 revocation of a token issued to clientId. Revoking a refresh token revokes
+// its whole family with the access tokens derived from it. Tokens that can't be verified or
+// are already revoked are ignored, hint only decides which token type is tried first.
+func (a Authorizer) Revoke(ctx context.Context, token, hint, clientId string) error {
+	uses := []TokenUse{TokenUseAccess, TokenUseRefresh}
+	if hint == "refresh_token" {
+		slices.Reverse(uses)
+	}
+
+	for _, use := range uses {
+		claims, err := a.verifyToken(token, use)
+		if err != nil {
+			continue
+		}
+		if claims.ClientId != clientId {
+			return ErrTokenClientMismatch
+		}
+		if use == TokenUseAccess {
+			if err := a.revokedTokens.Revoke(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+				return fmt.Errorf("revoke access token: %w", err)
+			}
+			return nil
+		}
+
+		stored, err := a.refreshTokens.GetByHash(ctx, opaque.Hash(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("get refresh token: %w", err)
+		}
+		return a.revokeFamily(ctx, stored.FamilyId)
+	}
+	return nil
+}
+
+// RevokeSession signs the user out of one session, revoking its refresh tokens and the access tokens
+// derived from them. sql.ErrNoRows is returned for sessions of other users and already revoked sessions.
+func (a Authorizer) RevokeSession(ctx context.Context, userId int64, sessionId string) error {
+	session, err := a.sessions.GetById(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	if session.UserId != userId || session.RevokedAt.Valid {
+		return fmt.Errorf("session not found: %w", sql.ErrNoRows)
+	}
+	return a.revokeFamily(ctx, session.Id)
+}
+
+// LogoutEverywhere revokes every access, refresh and personal access token issued to the user so far.
+// Personal access tokens don't carry the token generation, they are revoked outright.
+func (a Authorizer) LogoutEverywhere(ctx context.Context, userId int64) error {
+	if err := a.users.IncrementTokenGeneration(ctx, userId); err != nil {
+		return fmt.Errorf("increment token generation: %w", err)
+	}
+	if err := a.refreshTokens.RevokeBySubject(ctx, strconv.FormatInt(userId, 10)); err != nil {
+		return fmt.Errorf("revoke refresh tokens: %w", err)
+	}
+	if err := a.sessions.RevokeByUser(ctx, userId); err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	if err := a.personalAccessTokens.RevokeByUser(ctx, userId); err != nil {
+		return fmt.Errorf("revoke personal access tokens: %w", err)
+	}
+	return nil
+}
+
+// LogoutOtherSessions revokes every active session of the user but keepSessionId, an empty id keeps none.
+func (a Authorizer) LogoutOtherSessions(ctx context.Context, userId int64, keepSessionId string) error {
+	sessions, err := a.sessions.ListActiveByUser(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.Id == keepSessionId {
+			continue
+		}
+		if err := a.revokeFamily(ctx, session.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRefresh checks a refresh token is usable without consuming it.
+func (a Authorizer) validateRefresh(ctx context.Context, token string) (Claims, error) {
+	claims, stored, err := a.verifyRefreshToken(ctx, token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if stored.UsedAt.Valid {
+		return Claims{}, ErrTokenRevoked
+	}
+	if err := a.checkGeneration(ctx, claims); err != nil {
+		return Claims{}, err
+	}
+	return claims, nil
+}
+
+func (a Authorizer) verifyRefreshToken(ctx context.Context, token string) (Claims, db.RefreshToken, error) {
+	claims, err := a.verifyToken(token, TokenUseRefresh)
+	if err != nil {
+		return Claims{}, db.RefreshToken{}, fmt.Errorf("token verification: %w", err)
+	}
+
+	stored, err := a.refreshTokens.GetByHash(ctx, opaque.Hash(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Claims{}, db.RefreshToken{}, fmt.Errorf("refresh token not found")
+	}
+	if err != nil {
+		return Claims{}, db.RefreshToken{}, fmt.Errorf("get refresh token: %w", err)
+	}
+	if stored.Subject != claims.Subject {
+		return Claims{}, db.RefreshToken{}, fmt.Errorf("refresh token subject mismatch")
+	}
+	if stored.RevokedAt.Valid {
+		return Claims{}, db.RefreshToken{}, ErrTokenRevoked
+	}
+	return claims, stored, nil
+}
+
+func (a Authorizer) checkGeneration(ctx context.Context, claims Claims) error {
+	userId, err := claims.UserId()
+	if err != nil {
+		return err
+	}
+	generation, err := a.users.GetTokenGeneration(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("get token generation: %w", err)
+	}
+	if claims.Generation != generation {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+func (a Authorizer) revokeReusedFamily(ctx context.Context, familyId string) error {
+	if err := a.revokeFamily(ctx, familyId); err != nil {
+		return fmt.Errorf("revoke reused refresh token family: %w", err)
+	}
+	return ErrRefreshTokenReused
+}
+
+// revokeFamily ends the session of the family: it revokes the refresh tokens and, through the sid claim,
+// the access tokens derived from them. No access token of the family outlives the access token duration from now.
+func (a Authorizer) revokeFamily(ctx context.Context, familyId string) error {
+	if err := a.sessions.Revoke(ctx, familyId); err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	if err := a.refreshTokens.RevokeFamily(ctx, familyId); err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	if err := a.revokedTokens.Revoke(ctx, familyId, time.Now().Add(a.accessDuration)); err != nil {
+		return fmt.Errorf("revoke refresh token family access tokens: %w", err)
+	}
+	return nil
+}
+
+func (a Authorizer) createTokens(
+	ctx context.Context,
+	subject string,
+	generation int64,
+	familyId string,
+	auth Authentication,
+) (Tokens, db.RefreshToken, error) {
+	userId, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return Tokens{}, db.RefreshToken{}, fmt.Errorf("parse subject: %w", err)
+	}
+	restricted, err := a.isRestricted(ctx, userId)
+	if err != nil {
+		return Tokens{}, db.RefreshToken{}, err
+	}
+
+	accessClaims := a.newClaims(TokenUseAccess, subject, a.accessDuration)
+	accessClaims.SubjectType = SubjectTypeUser
+	accessClaims.Generation = generation
+	accessClaims.Scope = strings.Join(auth.Scope, " ")
+	if restricted {
+		accessClaims.Scope = strings.Join(restrictScope(auth.Scope), " ")
+	} else {
+		accessClaims.Roles, err = a.roles.GetUserRoles(ctx, userId)
+		if err != nil {
+			return Tokens{}, db.RefreshToken{}, fmt.Errorf("get roles: %w", err)
+		}
+		accessClaims.Permissions, err = a.roles.GetUserPermissions(ctx, userId)
+		if err != nil {
+			return Tokens{}, db.RefreshToken{}, fmt.Errorf("get permissions: %w", err)
+		}
+		// The organization role is read again on every refresh, removed members can't refresh their tokens.
+		if auth.OrgId != 0 {
+			accessClaims.OrgRole, err = a.memberships.GetMemberRole(ctx, auth.OrgId, userId)
+			if err != nil {
+				return Tokens{}, db.RefreshToken{}, fmt.Errorf("get organization role: %w", err)
+			}
+			accessClaims.OrgId = auth.OrgId
+		}
+	}
+	accessClaims.AuthTime = jwt.NewNumericDate(auth.AuthTime)
+	accessClaims.Amr = auth.Methods
+	accessClaims.ClientId = auth.ClientId
+	accessClaims.SessionId = familyId
+	accessToken, err := a.createToken(accessClaims)
+	if err != nil {
+		return Tokens{}, db.RefreshToken{}, fmt.Errorf("create access token: %w", err)
+	}
+
+	// The refresh token keeps the granted scope, once the email is verified a refresh lifts the restriction.
+	refreshClaims := a.newClaims(TokenUseRefresh, subject, a.refreshDuration)
+	refreshClaims.SubjectType = SubjectTypeUser
+	refreshClaims.Generation = generation
+	refreshClaims.Scope = strings.Join(auth.Scope, " ")
+	refreshClaims.AuthTime = accessClaims.AuthTime
+	refreshClaims.Amr = accessClaims.Amr
+	refreshClaims.ClientId = accessClaims.ClientId
+	refreshClaims.SessionId = familyId
+	refreshClaims.OrgId = auth.OrgId
+	refreshToken, err := a.createToken(refreshClaims)
+	if err != nil {
+		return Tokens{}, db.RefreshToken{}, fmt.Errorf("create refresh token: %w", err)
+	}
+
+	var idToken string
+	if slices.Contains(auth.Scope, ScopeOpenId) {
+		idToken, err = a.createIdToken(ctx, subject, auth)
+		if err != nil {
+			return Tokens{}, db.RefreshToken{}, err
+		}
+	}
+
+	return Tokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		IdToken:      idToken,
+		Scope:        accessClaims.Scopes(),
+		ExpiresIn:    a.accessDuration,
+	}, db.RefreshToken{
+		Id:        refreshClaims.ID,
+		FamilyId:  familyId,
+		Subject:   subject,
+		TokenHash: opaque.Hash(refreshToken),
+		ExpiresAt: refreshClaims.ExpiresAt.Time,
+	}, nil
+}
+
+// isRestricted reports whether tokens of the user are restricted until the email is verified.
+func (a Authorizer) isRestricted(ctx context.Context, userId int64) (bool, error) {
+	if !a.restrictUnverified {
+		return false, nil
+	}
+	user, err := a.users.GetById(ctx, userId)
+	if err != nil {
+		return false, fmt.Errorf("get user: %w", err)
+	}
+	return !user.EmailVerifiedAt.Valid, nil
+}
+
+func (a Authorizer) newClaims(use TokenUse, subject string, duration time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		TokenUse: use,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   subject,
+			Issuer:    a.issuer,
+			Audience:  jwt.ClaimStrings{a.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+		},
+	}
+}
+
+func (a Authorizer) createToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(a.keys.signing.method, claims)
+	if a.keys.signing.id != "" {
+		token.Header["kid"] = a.keys.signing.id
+	}
+
+	tokenString, err := token.SignedString(a.keys.signing.sign)
+	if err != nil {
+		return "", fmt.Errorf("token sign: %w", err)
+	}
+	return tokenString, nil
+}
+
+// verifyToken checks the signature, the registered claims and the token use.
+func (a Authorizer) verifyToken(tokenString string, use TokenUse) (Claims, error) {
+	var claims Claims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, a.keys.keyFunc)
+	if err != nil {
+		return Claims{}, fmt.Errorf("parse token: %w", err)
+	}
+	if !token.Valid {
+		return Claims{}, fmt.Errorf("token not valid")
+	}
+	if !claims.VerifyIssuer(a.issuer, true) {
+		return Claims{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(a.audience, true) {
+		return Claims{}, fmt.Errorf("unexpected audience %v", claims.Audience)
+	}
+	if claims.TokenUse != use {
+		return Claims{}, ErrWrongTokenUse
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil || claims.IssuedAt == nil {
+		return Claims{}, fmt.Errorf("claim not found")
+	}
+	if use != TokenUseState && (claims.Subject == "" || claims.AuthTime == nil) {
+		return Claims{}, fmt.Errorf("claim not found")
 	}
-	return token.Valid, identity, nil
+	return claims, nil
 }
@@ -0,0 +1,260 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+	"sort"
+)
+
+// JWK is a public key in the RFC 7517 format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type key struct {
+	id     string
+	method jwt.SigningMethod
+	// sign is nil for keys used only to verify tokens signed before a key rotation.
+	sign   any
+	verify any
+}
+
+type keySet struct {
+	signing key
+	keys    map[string]key
+}
+
+func loadKeySet(config Config) (keySet, error) {
+	method := jwt.GetSigningMethod(config.JwtSigningMethod)
+	if method == nil {
+		return keySet{}, fmt.Errorf("unsupported signing method %q", config.JwtSigningMethod)
+	}
+
+	var signing key
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		if config.JwtSecretKey == "" {
+			return keySet{}, fmt.Errorf("secret key is required for %s", method.Alg())
+		}
+		signing = key{
+			id:     config.JwtKeyId,
+			method: method,
+			sign:   []byte(config.JwtSecretKey),
+			verify: []byte(config.JwtSecretKey),
+		}
+	} else {
+		data, err := os.ReadFile(config.JwtPrivateKeyPath)
+		if err != nil {
+			return keySet{}, fmt.Errorf("read private key: %w", err)
+		}
+		privateKey, err := parsePrivateKey(method, data)
+		if err != nil {
+			return keySet{}, fmt.Errorf("parse private key: %w", err)
+		}
+		signing, err = newAsymmetricKey(config.JwtKeyId, method, privateKey.Public())
+		if err != nil {
+			return keySet{}, err
+		}
+		signing.sign = privateKey
+	}
+
+	set := keySet{
+		signing: signing,
+		keys:    map[string]key{signing.id: signing},
+	}
+	for _, path := range config.JwtPublicKeyPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return keySet{}, fmt.Errorf("read public key %s: %w", path, err)
+		}
+		verifying, err := parsePublicKey(method, data)
+		if err != nil {
+			return keySet{}, fmt.Errorf("parse public key %s: %w", path, err)
+		}
+		set.keys[verifying.id] = verifying
+	}
+	return set, nil
+}
+
+// keyFunc selects the verification key by the kid header and rejects
+// tokens whose algorithm does not belong to the key.
+func (s keySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return k.verify, nil
+}
+
+func (s keySet) jwks() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, k := range s.keys {
+		jwk, ok := publicJWK(k)
+		if ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
+
+func parsePrivateKey(method jwt.SigningMethod, data []byte) (crypto.Signer, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPrivateKeyFromPEM(data)
+	case *jwt.SigningMethodECDSA:
+		return jwt.ParseECPrivateKeyFromPEM(data)
+	case *jwt.SigningMethodEd25519:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return privateKey.(ed25519.PrivateKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported signing method %s", method.Alg())
+	}
+}
+
+// parsePublicKey builds a verification key for a key rotated out. The algorithm of RSA keys can't be told
+// from the key, they are verified with the configured method, which must be an RS or PS one.
+// EC keys get the algorithm of their curve and Ed25519 keys EdDSA.
+func parsePublicKey(configured jwt.SigningMethod, data []byte) (key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key{}, fmt.Errorf("key must be PEM encoded")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return key{}, fmt.Errorf("parse PKIX public key: %w", err)
+	}
+
+	var method jwt.SigningMethod
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch configured.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			method = configured
+		default:
+			return key{}, fmt.Errorf("RSA public key requires an RS or PS signing method, not %s", configured.Alg())
+		}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return key{}, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return key{}, fmt.Errorf("unsupported public key type %T", publicKey)
+	}
+	return newAsymmetricKey("", method, publicKey)
+}
+
+// newAsymmetricKey builds a verification key, an empty id is replaced by the RFC 7638 thumbprint.
+func newAsymmetricKey(id string, method jwt.SigningMethod, publicKey crypto.PublicKey) (key, error) {
+	k := key{
+		id:     id,
+		method: method,
+		verify: publicKey,
+	}
+	jwk, ok := publicJWK(k)
+	if !ok {
+		return key{}, fmt.Errorf("public key %T doesn't match signing method %s", publicKey, method.Alg())
+	}
+	if k.id == "" {
+		k.id = thumbprint(jwk)
+	}
+	return k, nil
+}
+
+func publicJWK(k key) (JWK, bool) {
+	jwk := JWK{
+		Kid: k.id,
+		Use: "sig",
+		Alg: k.method.Alg(),
+	}
+	switch pub := k.verify.(type) {
+	case *rsa.PublicKey:
+		switch k.method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return JWK{}, false
+		}
+		jwk.Kty = "RSA"
+		jwk.N = encodeBase64(pub.N.Bytes())
+		jwk.E = encodeBase64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		method, ok := k.method.(*jwt.SigningMethodECDSA)
+		if !ok || pub.Curve.Params().BitSize != method.CurveBits {
+			return JWK{}, false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeBase64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeBase64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		if _, ok := k.method.(*jwt.SigningMethodEd25519); !ok {
+			return JWK{}, false
+		}
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBase64(pub)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// thumbprint computes the RFC 7638 key thumbprint, members are listed in lexicographic order.
+func thumbprint(jwk JWK) string {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return encodeBase64(sum[:])
+}
+
+func encodeBase64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
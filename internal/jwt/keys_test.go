@@ -0,0 +1,308 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writePrivateKey(t *testing.T, privateKey crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PRIVATE KEY", der)
+}
+
+func writePublicKey(t *testing.T, publicKey crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, "PUBLIC KEY", der)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedECKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		// algs are the expected algorithms of the signing key followed by the rotated keys.
+		algs    []string
+		wantErr bool
+	}{
+		{
+			name:   "hmac",
+			config: Config{JwtSigningMethod: "HS256", JwtSecretKey: "secret", JwtKeyId: "hs"},
+			algs:   []string{"HS256"},
+		},
+		{
+			name:    "hmac without secret",
+			config:  Config{JwtSigningMethod: "HS256"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported method",
+			config:  Config{JwtSigningMethod: "none"},
+			wantErr: true,
+		},
+		{
+			name: "rs384 with rotated rsa key",
+			config: Config{
+				JwtSigningMethod:  "RS384",
+				JwtPrivateKeyPath: writePrivateKey(t, rsaKey),
+				JwtPublicKeyPaths: []string{writePublicKey(t, rotatedRSAKey.Public())},
+			},
+			algs: []string{"RS384", "RS384"},
+		},
+		{
+			name: "ps512 with rotated rsa key",
+			config: Config{
+				JwtSigningMethod:  "PS512",
+				JwtPrivateKeyPath: writePrivateKey(t, rsaKey),
+				JwtPublicKeyPaths: []string{writePublicKey(t, rotatedRSAKey.Public())},
+			},
+			algs: []string{"PS512", "PS512"},
+		},
+		{
+			name: "es256 with rotated p-384 key",
+			config: Config{
+				JwtSigningMethod:  "ES256",
+				JwtPrivateKeyPath: writePrivateKey(t, ecKey),
+				JwtPublicKeyPaths: []string{writePublicKey(t, rotatedECKey.Public())},
+			},
+			algs: []string{"ES256", "ES384"},
+		},
+		{
+			name: "eddsa",
+			config: Config{
+				JwtSigningMethod:  "EdDSA",
+				JwtPrivateKeyPath: writePrivateKey(t, edKey),
+			},
+			algs: []string{"EdDSA"},
+		},
+		{
+			name: "rotated rsa key without an rsa method",
+			config: Config{
+				JwtSigningMethod:  "ES256",
+				JwtPrivateKeyPath: writePrivateKey(t, ecKey),
+				JwtPublicKeyPaths: []string{writePublicKey(t, rotatedRSAKey.Public())},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ec private key with an rsa method",
+			config: Config{
+				JwtSigningMethod:  "RS256",
+				JwtPrivateKeyPath: writePrivateKey(t, ecKey),
+			},
+			wantErr: true,
+		},
+		{
+			name: "p-384 private key with es256",
+			config: Config{
+				JwtSigningMethod:  "ES256",
+				JwtPrivateKeyPath: writePrivateKey(t, rotatedECKey),
+			},
+			wantErr: true,
+		},
+		{
+			name: "public key not PEM encoded",
+			config: Config{
+				JwtSigningMethod:  "EdDSA",
+				JwtPrivateKeyPath: writePrivateKey(t, edKey),
+				JwtPublicKeyPaths: []string{writePEM(t, "PUBLIC KEY", []byte("garbage"))},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := loadKeySet(tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(set.keys) != len(tt.algs) {
+				t.Fatalf("got %d keys, want %d", len(set.keys), len(tt.algs))
+			}
+			if got := set.signing.method.Alg(); got != tt.algs[0] {
+				t.Errorf("signing alg %s, want %s", got, tt.algs[0])
+			}
+			for id, k := range set.keys {
+				if id == set.signing.id {
+					continue
+				}
+				if got := k.method.Alg(); got != tt.algs[1] {
+					t.Errorf("rotated key alg %s, want %s", got, tt.algs[1])
+				}
+				if k.sign != nil {
+					t.Error("rotated key can sign")
+				}
+			}
+		})
+	}
+}
+
+func TestKeySetVerifiesWithKeyAlgorithm(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := loadKeySet(Config{JwtSigningMethod: "RS384", JwtPrivateKeyPath: writePrivateKey(t, privateKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		method  jwt.SigningMethod
+		kid     string
+		wantErr bool
+	}{
+		{name: "configured alg", method: jwt.SigningMethodRS384, kid: set.signing.id},
+		{name: "other rsa alg", method: jwt.SigningMethodRS256, kid: set.signing.id, wantErr: true},
+		{name: "pss alg", method: jwt.SigningMethodPS384, kid: set.signing.id, wantErr: true},
+		{name: "unknown kid", method: jwt.SigningMethodRS384, kid: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, jwt.RegisteredClaims{Subject: "1"})
+			token.Header["kid"] = tt.kid
+			signed, err := token.SignedString(privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = jwt.ParseWithClaims(signed, &jwt.RegisteredClaims{}, set.keyFunc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := loadKeySet(Config{
+		JwtSigningMethod:  "PS256",
+		JwtPrivateKeyPath: writePrivateKey(t, rsaKey),
+		JwtKeyId:          "current",
+		JwtPublicKeyPaths: []string{writePublicKey(t, ecKey.Public()), writePublicKey(t, edPublic)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := set.jwks()
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("got %d keys, want 3", len(jwks.Keys))
+	}
+	for i := 1; i < len(jwks.Keys); i++ {
+		if jwks.Keys[i-1].Kid > jwks.Keys[i].Kid {
+			t.Error("keys are not sorted by kid")
+		}
+	}
+
+	byAlg := map[string]JWK{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "sig" {
+			t.Errorf("%s use %q", jwk.Alg, jwk.Use)
+		}
+		byAlg[jwk.Alg] = jwk
+	}
+	rsaJWK, ok := byAlg["PS256"]
+	if !ok || rsaJWK.Kty != "RSA" || rsaJWK.Kid != "current" || rsaJWK.N == "" || rsaJWK.E != "AQAB" {
+		t.Errorf("rsa jwk %+v", rsaJWK)
+	}
+	ecJWK, ok := byAlg["ES384"]
+	if !ok || ecJWK.Kty != "EC" || ecJWK.Crv != "P-384" || len(ecJWK.X) != 64 || len(ecJWK.Y) != 64 {
+		t.Errorf("ec jwk %+v", ecJWK)
+	}
+	if ecJWK.Kid != thumbprint(ecJWK) {
+		t.Errorf("ec kid %s is not the thumbprint", ecJWK.Kid)
+	}
+	edJWK, ok := byAlg["EdDSA"]
+	if !ok || edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.X != encodeBase64(edPublic) {
+		t.Errorf("ed25519 jwk %+v", edJWK)
+	}
+}
+
+func TestJWKSOmitsHMACKeys(t *testing.T) {
+	set, err := loadKeySet(Config{JwtSigningMethod: "HS256", JwtSecretKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := set.jwks().Keys; len(keys) != 0 {
+		t.Errorf("published %d hmac keys", len(keys))
+	}
+}
+
+// TestThumbprint checks the RFC 7638 section 3.1 example.
+func TestThumbprint(t *testing.T) {
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMs" +
+			"tn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91Cb" +
+			"OpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	if got, want := thumbprint(jwk), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/golang-jwt/jwt/v4"
+	"slices"
+	"time"
+)
+
+// OpenID Connect scopes, profile and email release the matching UserInfo claims.
+const (
+	ScopeOpenId  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// Authentication method references (amr) of the supported sign in methods.
+const (
+	AuthMethodPassword  = "pwd"
+	AuthMethodFederated = "fed"
+)
+
+var oidcScopes = []string{ScopeOpenId, ScopeProfile, ScopeEmail}
+
+// Authentication describes a completed sign in, it is carried over refresh token rotations.
+type Authentication struct {
+	Scope    []string
+	Nonce    string
+	AuthTime time.Time
+	Methods  []string
+	// ClientId is the oauth2 client the user signed in to, it becomes the id token audience.
+	ClientId string
+	// OrgId is the organization the tokens are scoped to, zero for tokens outside of any organization.
+	OrgId int64
+	// UserAgent and IpAddress describe the device the session is started on, they aren't carried over refreshes.
+	UserAgent string
+	IpAddress string
+}
+
+// UserInfo holds the OpenID Connect standard claims released for the granted scopes.
+type UserInfo struct {
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+type IdTokenClaims struct {
+	UserInfo
+	// AuthorizedParty is the client the id token was issued to.
+	AuthorizedParty string `json:"azp,omitempty"`
+	Claims
+}
+
+// NewUserInfo maps the user to the claims allowed by scope.
+func NewUserInfo(user db.User, scope []string) UserInfo {
+	var info UserInfo
+	if slices.Contains(scope, ScopeProfile) {
+		info.PreferredUsername = user.Login
+	}
+	if slices.Contains(scope, ScopeEmail) {
+		verified := user.EmailVerifiedAt.Valid
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	return info
+}
+
+// SigningAlgorithm returns the alg tokens are signed with.
+func (a Authorizer) SigningAlgorithm() string {
+	return a.keys.signing.method.Alg()
+}
+
+func (a Authorizer) createIdToken(ctx context.Context, subject string, auth Authentication) (string, error) {
+	claims := a.newClaims(TokenUseId, subject, a.accessDuration)
+	claims.Nonce = auth.Nonce
+	claims.AuthTime = jwt.NewNumericDate(auth.AuthTime)
+	claims.Amr = auth.Methods
+	if auth.ClientId != "" {
+		claims.Audience = jwt.ClaimStrings{auth.ClientId}
+	}
+
+	userId, err := claims.UserId()
+	if err != nil {
+		return "", err
+	}
+	user, err := a.users.GetById(ctx, userId)
+	if err != nil {
+		return "", fmt.Errorf("get user: %w", err)
+	}
+
+	idToken, err := a.createToken(IdTokenClaims{
+		UserInfo:        NewUserInfo(user, auth.Scope),
+		AuthorizedParty: auth.ClientId,
+		Claims:          claims,
+	})
+	if err != nil {
+		return "", fmt.Errorf("create id token: %w", err)
+	}
+	return idToken, nil
+}
@@ -0,0 +1,85 @@
+package jwt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/golang-jwt/jwt/v4"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, it tells them apart from JWTs
+// and makes leaked tokens easy to find for secret scanners.
+const PersonalAccessTokenPrefix = "gapat_"
+
+// personalAccessTokenPrefixLength is the part of a token kept in clear to recognize it in listings.
+const personalAccessTokenPrefixLength = len(PersonalAccessTokenPrefix) + 4
+
+// CreatePersonalAccessToken issues a long-lived opaque token of the user, only its hash is stored.
+// A zero expiresAt keeps the token valid until it is revoked.
+func (a Authorizer) CreatePersonalAccessToken(
+	ctx context.Context,
+	userId int64,
+	name string,
+	scope []string,
+	expiresAt time.Time,
+) (string, db.PersonalAccessToken, error) {
+	random, err := opaque.Generate()
+	if err != nil {
+		return "", db.PersonalAccessToken{}, fmt.Errorf("generate personal access token: %w", err)
+	}
+	token := PersonalAccessTokenPrefix + random
+
+	stored, err := a.personalAccessTokens.Insert(ctx, db.PersonalAccessToken{
+		UserId:      userId,
+		Name:        name,
+		TokenHash:   opaque.Hash(token),
+		TokenPrefix: token[:personalAccessTokenPrefixLength],
+		Scope:       strings.Join(scope, " "),
+		ExpiresAt:   sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()},
+	})
+	if err != nil {
+		return "", db.PersonalAccessToken{}, fmt.Errorf("store personal access token: %w", err)
+	}
+	return token, stored, nil
+}
+
+// validatePersonalAccessToken maps an active personal access token to the claims of an access token.
+// The tokens don't carry the token generation, LogoutEverywhere revokes them instead.
+func (a Authorizer) validatePersonalAccessToken(ctx context.Context, token string) (Claims, error) {
+	stored, err := a.personalAccessTokens.GetActiveByHash(ctx, opaque.Hash(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Claims{}, fmt.Errorf("personal access token not found")
+	}
+	if err != nil {
+		return Claims{}, fmt.Errorf("get personal access token: %w", err)
+	}
+	// The last use is informational, a failed write must not fail the authentication.
+	if err := a.personalAccessTokens.Touch(ctx, stored.Id); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("record personal access token use: %s", err.Error()))
+	}
+
+	claims := Claims{
+		TokenUse:    TokenUsePersonal,
+		SubjectType: SubjectTypeUser,
+		Scope:       stored.Scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        PersonalAccessTokenPrefix + strconv.FormatInt(stored.Id, 10),
+			Subject:   strconv.FormatInt(stored.UserId, 10),
+			Issuer:    a.issuer,
+			Audience:  jwt.ClaimStrings{a.audience},
+			IssuedAt:  jwt.NewNumericDate(stored.CreatedAt),
+			NotBefore: jwt.NewNumericDate(stored.CreatedAt),
+		},
+	}
+	if stored.ExpiresAt.Valid {
+		claims.ExpiresAt = jwt.NewNumericDate(stored.ExpiresAt.Time)
+	}
+	return claims, nil
+}
@@ -0,0 +1,115 @@
+package jwt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"testing"
+	"time"
+)
+
+type testPersonalAccessTokens struct {
+	tokens   map[int64]*db.PersonalAccessToken
+	touchErr error
+}
+
+func (s *testPersonalAccessTokens) Insert(_ context.Context, token db.PersonalAccessToken) (db.PersonalAccessToken, error) {
+	token.Id = int64(len(s.tokens) + 1)
+	token.CreatedAt = time.Now()
+	s.tokens[token.Id] = &token
+	return token, nil
+}
+
+func (s *testPersonalAccessTokens) GetActiveByHash(_ context.Context, hash string) (db.PersonalAccessToken, error) {
+	for _, token := range s.tokens {
+		if token.TokenHash == hash && !token.RevokedAt.Valid {
+			return *token, nil
+		}
+	}
+	return db.PersonalAccessToken{}, sql.ErrNoRows
+}
+
+func (s *testPersonalAccessTokens) Touch(_ context.Context, id int64) error {
+	if s.touchErr != nil {
+		return s.touchErr
+	}
+	s.tokens[id].LastUsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (s *testPersonalAccessTokens) RevokeByUser(_ context.Context, userId int64) error {
+	for _, token := range s.tokens {
+		if token.UserId == userId {
+			token.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+// testRefreshTokens and testSessions only implement the revocations of LogoutEverywhere.
+type testRefreshTokens struct{ refreshTokenStore }
+
+func (testRefreshTokens) RevokeBySubject(context.Context, string) error { return nil }
+
+type testSessions struct{ sessionStore }
+
+func (testSessions) RevokeByUser(context.Context, int64) error { return nil }
+
+func TestLogoutEverywhereRevokesPersonalAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	tokens := &testPersonalAccessTokens{tokens: map[int64]*db.PersonalAccessToken{}}
+	a, err := NewAuthorizer(Config{JwtSigningMethod: "HS256", JwtSecretKey: "secret"},
+		testRefreshTokens{}, testSessions{}, nil, testUsers{}, testRoles{}, nil, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedOut, _, err := a.CreatePersonalAccessToken(ctx, 1, "deploy", []string{"profile"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := a.CreatePersonalAccessToken(ctx, 2, "deploy", []string{"profile"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.validatePersonalAccessToken(ctx, signedOut); err != nil {
+		t.Fatalf("token rejected before the logout: %v", err)
+	}
+
+	if err := a.LogoutEverywhere(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.validatePersonalAccessToken(ctx, signedOut); err == nil {
+		t.Error("token of the signed out user accepted")
+	}
+	if _, err := a.validatePersonalAccessToken(ctx, other); err != nil {
+		t.Errorf("token of another user rejected: %v", err)
+	}
+}
+
+func TestPersonalAccessTokenUseIsBestEffort(t *testing.T) {
+	ctx := context.Background()
+	tokens := &testPersonalAccessTokens{tokens: map[int64]*db.PersonalAccessToken{}}
+	a, err := NewAuthorizer(Config{JwtSigningMethod: "HS256", JwtSecretKey: "secret"},
+		nil, nil, nil, testUsers{}, testRoles{}, nil, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, stored, err := a.CreatePersonalAccessToken(ctx, 1, "deploy", []string{"profile"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens.touchErr = errors.New("database is read only")
+	claims, err := a.validatePersonalAccessToken(ctx, token)
+	if err != nil {
+		t.Fatalf("token rejected when the last use can't be recorded: %v", err)
+	}
+	if claims.Subject != "1" || claims.Scope != "profile" || claims.TokenUse != TokenUsePersonal {
+		t.Errorf("claims %+v", claims)
+	}
+	if tokens.tokens[stored.Id].LastUsedAt.Valid {
+		t.Error("last use recorded")
+	}
+}
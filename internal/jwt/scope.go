@@ -0,0 +1,96 @@
+package jwt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ScopeUnverifiedEmail replaces the api scopes of users with an unverified email when JwtRestrictUnverifiedEmail
+// is set, middlewares.RequireVerifiedEmail rejects tokens carrying it.
+const ScopeUnverifiedEmail = "unverified_email"
+
+// newScopes returns the OpenID Connect scopes with the configured api scopes
+// and checks the default scopes are among them.
+func newScopes(config Config) ([]string, []string, error) {
+	scopes := slices.Clone(oidcScopes)
+	for _, scope := range config.JwtScopes {
+		if scope == ScopeUnverifiedEmail {
+			return nil, nil, fmt.Errorf("scope %q is reserved", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	for _, scope := range config.JwtDefaultScopes {
+		if !slices.Contains(scopes, scope) {
+			return nil, nil, fmt.Errorf("default scope %q is not supported", scope)
+		}
+	}
+	return scopes, slices.Clone(config.JwtDefaultScopes), nil
+}
+
+// GrantScopes returns the requested scopes the server supports, requested is space separated.
+// The default scopes are granted when nothing was requested. It is meant for first-party callers,
+// GrantAllowedScopes bounds the scopes of clients and derived tokens.
+func (a Authorizer) GrantScopes(requested string) []string {
+	return a.grantScopes(requested, nil)
+}
+
+// GrantAllowedScopes is GrantScopes keeping only scopes in allowed, nothing is granted when allowed is empty.
+func (a Authorizer) GrantAllowedScopes(requested string, allowed []string) []string {
+	return a.grantScopes(requested, func(scope string) bool {
+		return slices.Contains(allowed, scope)
+	})
+}
+
+func (a Authorizer) grantScopes(requested string, isAllowed func(scope string) bool) []string {
+	if strings.TrimSpace(requested) == "" {
+		requested = strings.Join(a.defaultScopes, " ")
+	}
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(a.scopes, scope) || slices.Contains(granted, scope) {
+			continue
+		}
+		if isAllowed != nil && !isAllowed(scope) {
+			continue
+		}
+		granted = append(granted, scope)
+	}
+	return granted
+}
+
+// GrantClientScopes returns the requested scopes from the client allowed list, all of them
+// when nothing was requested. OpenID Connect scopes are dropped, there is no user behind a client.
+func GrantClientScopes(requested string, allowed []string) []string {
+	if strings.TrimSpace(requested) == "" {
+		requested = strings.Join(allowed, " ")
+	}
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if slices.Contains(oidcScopes, scope) || slices.Contains(granted, scope) {
+			continue
+		}
+		if slices.Contains(allowed, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+// restrictScope keeps the OpenID Connect scopes and marks the scope with ScopeUnverifiedEmail.
+func restrictScope(scope []string) []string {
+	var restricted []string
+	for _, s := range scope {
+		if slices.Contains(oidcScopes, s) {
+			restricted = append(restricted, s)
+		}
+	}
+	return append(restricted, ScopeUnverifiedEmail)
+}
+
+// SupportedScopes lists scopes which can be requested.
+func (a Authorizer) SupportedScopes() []string {
+	return slices.Clone(a.scopes)
+}
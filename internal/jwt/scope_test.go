@@ -0,0 +1,241 @@
+package jwt
+
+import (
+	"context"
+	"database/sql"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/golang-jwt/jwt/v4"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testUsers map[int64]db.User
+
+func (u testUsers) GetById(_ context.Context, id int64) (db.User, error) {
+	user, ok := u[id]
+	if !ok {
+		return db.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (u testUsers) GetTokenGeneration(_ context.Context, userId int64) (int64, error) {
+	return u[userId].TokenGeneration, nil
+}
+
+func (u testUsers) IncrementTokenGeneration(context.Context, int64) error {
+	return nil
+}
+
+type testRoles struct{}
+
+func (testRoles) GetUserRoles(context.Context, int64) ([]string, error) {
+	return []string{"admin"}, nil
+}
+
+func (testRoles) GetUserPermissions(context.Context, int64) ([]string, error) {
+	return []string{"users:read"}, nil
+}
+
+func newScopeAuthorizer(t *testing.T, restrictUnverified bool) Authorizer {
+	t.Helper()
+	a, err := NewAuthorizer(Config{
+		JwtSigningMethod:           "HS256",
+		JwtSecretKey:               "secret",
+		JwtScopes:                  []string{"api:read", "api:write"},
+		JwtDefaultScopes:           []string{"openid", "api:read"},
+		JwtAccessTokenHours:        1,
+		JwtRefreshTokenHours:       1,
+		JwtRestrictUnverifiedEmail: restrictUnverified,
+	}, nil, nil, nil, testUsers{
+		1: {Id: 1, Login: "verified", EmailVerifiedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+		2: {Id: 2, Login: "unverified"},
+	}, testRoles{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestNewScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		scopes   []string
+		defaults []string
+		wantErr  bool
+	}{
+		{
+			name:   "openid connect scopes only",
+			scopes: []string{ScopeOpenId, ScopeProfile, ScopeEmail},
+		},
+		{
+			name:     "api scopes",
+			config:   Config{JwtScopes: []string{"api:read", "openid", "api:read"}, JwtDefaultScopes: []string{"api:read"}},
+			scopes:   []string{ScopeOpenId, ScopeProfile, ScopeEmail, "api:read"},
+			defaults: []string{"api:read"},
+		},
+		{
+			name:    "reserved scope",
+			config:  Config{JwtScopes: []string{ScopeUnverifiedEmail}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported default scope",
+			config:  Config{JwtScopes: []string{"api:read"}, JwtDefaultScopes: []string{"api:write"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopes, defaults, err := newScopes(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(scopes, tt.scopes) || !slices.Equal(defaults, tt.defaults) {
+				t.Errorf("scopes %v defaults %v, want %v %v", scopes, defaults, tt.scopes, tt.defaults)
+			}
+		})
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	a := newScopeAuthorizer(t, false)
+	tests := []struct {
+		name      string
+		requested string
+		want      []string
+	}{
+		{name: "nothing requested", requested: " ", want: []string{"openid", "api:read"}},
+		{name: "subset", requested: "openid api:write", want: []string{"openid", "api:write"}},
+		{name: "all supported", requested: "openid profile email api:read api:write", want: []string{"openid", "profile", "email", "api:read", "api:write"}},
+		{name: "unknown dropped", requested: "api:read admin api:delete", want: []string{"api:read"}},
+		{name: "only unknown", requested: "admin", want: nil},
+		{name: "duplicates", requested: "api:read api:read", want: []string{"api:read"}},
+		{name: "reserved scope", requested: "api:read " + ScopeUnverifiedEmail, want: []string{"api:read"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.GrantScopes(tt.requested); !slices.Equal(got, tt.want) {
+				t.Errorf("GrantScopes(%q) = %v, want %v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantAllowedScopes(t *testing.T) {
+	a := newScopeAuthorizer(t, false)
+	tests := []struct {
+		name      string
+		requested string
+		allowed   []string
+		want      []string
+	}{
+		{name: "subset of allowed", requested: "openid", allowed: []string{"openid", "api:read"}, want: []string{"openid"}},
+		{name: "equal to allowed", requested: "openid api:read", allowed: []string{"openid", "api:read"}, want: []string{"openid", "api:read"}},
+		{name: "superset of allowed", requested: "openid api:read api:write", allowed: []string{"openid", "api:read"}, want: []string{"openid", "api:read"}},
+		{name: "disjoint from allowed", requested: "api:write", allowed: []string{"openid"}, want: nil},
+		{name: "unknown scope allowed", requested: "api:read legacy", allowed: []string{"api:read", "legacy"}, want: []string{"api:read"}},
+		{name: "nothing allowed", requested: "openid api:read", allowed: nil, want: nil},
+		{name: "nothing requested", requested: "", allowed: []string{"api:read", "api:write"}, want: []string{"api:read"}},
+		{name: "nothing requested nor allowed", requested: "", allowed: nil, want: nil},
+		{name: "reserved scope allowed", requested: ScopeUnverifiedEmail, allowed: []string{ScopeUnverifiedEmail}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.GrantAllowedScopes(tt.requested, tt.allowed); !slices.Equal(got, tt.want) {
+				t.Errorf("GrantAllowedScopes(%q, %v) = %v, want %v", tt.requested, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrantClientScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		allowed   []string
+		want      []string
+	}{
+		{name: "nothing requested", requested: "", allowed: []string{"openid", "api:read", "api:write"}, want: []string{"api:read", "api:write"}},
+		{name: "subset", requested: "api:read", allowed: []string{"api:read", "api:write"}, want: []string{"api:read"}},
+		{name: "superset", requested: "api:read api:write api:delete", allowed: []string{"api:read"}, want: []string{"api:read"}},
+		{name: "openid connect scopes dropped", requested: "openid profile api:read", allowed: []string{"openid", "profile", "api:read"}, want: []string{"api:read"}},
+		{name: "unknown scope", requested: "admin", allowed: []string{"api:read"}, want: nil},
+		{name: "nothing allowed", requested: "api:read", allowed: nil, want: nil},
+		{name: "duplicates", requested: "api:read api:read", allowed: []string{"api:read"}, want: []string{"api:read"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GrantClientScopes(tt.requested, tt.allowed); !slices.Equal(got, tt.want) {
+				t.Errorf("GrantClientScopes(%q, %v) = %v, want %v", tt.requested, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestrictedScopeOfUnverifiedUsers(t *testing.T) {
+	tests := []struct {
+		name               string
+		restrictUnverified bool
+		userId             int64
+		wantScope          []string
+		wantRoles          bool
+	}{
+		{
+			name:               "verified user",
+			restrictUnverified: true,
+			userId:             1,
+			wantScope:          []string{"openid", "api:read", "api:write"},
+			wantRoles:          true,
+		},
+		{
+			name:               "unverified user",
+			restrictUnverified: true,
+			userId:             2,
+			wantScope:          []string{"openid", ScopeUnverifiedEmail},
+		},
+		{
+			name:      "unverified user without the restriction",
+			userId:    2,
+			wantScope: []string{"openid", "api:read", "api:write"},
+			wantRoles: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newScopeAuthorizer(t, tt.restrictUnverified)
+			tokens, refreshToken, err := a.createTokens(context.Background(), strconv.FormatInt(tt.userId, 10), 0, "family", Authentication{
+				Scope:    a.GrantScopes("openid api:read api:write"),
+				AuthTime: time.Now(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(tokens.Scope, tt.wantScope) {
+				t.Errorf("scope %v, want %v", tokens.Scope, tt.wantScope)
+			}
+
+			var access Claims
+			if _, err := jwt.ParseWithClaims(tokens.AccessToken, &access, a.keys.keyFunc); err != nil {
+				t.Fatal(err)
+			}
+			if got := access.HasRole("admin"); got != tt.wantRoles {
+				t.Errorf("access token has roles %v, want %v", got, tt.wantRoles)
+			}
+			// The refresh token keeps the granted scope, a refresh after the verification lifts the restriction.
+			var refresh Claims
+			if _, err := jwt.ParseWithClaims(tokens.RefreshToken, &refresh, a.keys.keyFunc); err != nil {
+				t.Fatal(err)
+			}
+			if got := refresh.Scopes(); !slices.Equal(got, []string{"openid", "api:read", "api:write"}) {
+				t.Errorf("refresh token scope %v", got)
+			}
+			if refreshToken.FamilyId != "family" {
+				t.Errorf("family %s", refreshToken.FamilyId)
+			}
+		})
+	}
+}
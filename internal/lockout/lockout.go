@@ -0,0 +1,267 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	unlockDuration  = 24 * time.Hour
+	tokenQueryParam = "token"
+	// attemptsRetention is how long failed sign ins are kept at least, for the IP velocity check
+	// and the lockouts without further attempts.
+	attemptsRetention = 24 * time.Hour
+	cleanupInterval   = 10 * time.Minute
+)
+
+var (
+	ErrBackoff   = errors.New("sign in attempted too early")
+	ErrLocked    = errors.New("account locked")
+	ErrIpBlocked = errors.New("too many failed sign ins from the ip address")
+)
+
+type Config struct {
+	// LockoutThreshold is how many failed sign ins in a row lock the account for LockoutMinutes.
+	LockoutThreshold int `env:"LOCKOUT_THRESHOLD, default=10"`
+	LockoutMinutes   int `env:"LOCKOUT_MINUTES, default=30"`
+	// LockoutBackoffSeconds is the wait after a failed sign in, it doubles with every further failure.
+	LockoutBackoffSeconds int `env:"LOCKOUT_BACKOFF_SECONDS, default=1"`
+	// LockoutIpMaxLogins is how many different logins may fail to sign in from an IP address
+	// within LockoutIpWindowMinutes before the address is refused.
+	LockoutIpMaxLogins     int `env:"LOCKOUT_IP_MAX_LOGINS, default=20"`
+	LockoutIpWindowMinutes int `env:"LOCKOUT_IP_WINDOW_MINUTES, default=15"`
+}
+
+type (
+	emailSender interface {
+		SendTemplate(ctx context.Context, email mailer.Email) error
+	}
+	lockoutStore interface {
+		GetByKey(ctx context.Context, key db.LockoutKey) (db.Lockout, error)
+		Reserve(ctx context.Context, key db.LockoutKey, backoff, lock time.Duration, threshold int) (int, error)
+		Lock(ctx context.Context, key db.LockoutKey, lockedUntil time.Time) error
+		Reset(ctx context.Context, userId int64) error
+		DeleteStale(ctx context.Context, before time.Time) error
+	}
+	attemptStore interface {
+		Insert(ctx context.Context, login string, userId sql.NullInt64, ipAddress string) (int64, error)
+		Delete(ctx context.Context, id int64) error
+		CountLoginsByIp(ctx context.Context, ipAddress string, since time.Time, exceptId int64) (int, error)
+		DeleteBefore(ctx context.Context, before time.Time) error
+	}
+	unlockStore interface {
+		Insert(ctx context.Context, unlock db.AccountUnlock) error
+		Use(ctx context.Context, hash string) (int64, error)
+	}
+)
+
+// Guard protects password sign ins from brute force: every failed sign in of a user delays the next one
+// exponentially, the account is locked at the threshold and the user emailed an unlock link.
+// Unknown logins are delayed and locked the same way, so refusals don't tell which accounts exist.
+// An IP address failing for too many different logins is refused altogether.
+// Attempts are reserved before the password is checked, parallel sign ins are counted like sequential ones.
+type Guard struct {
+	config      Config
+	lockouts    lockoutStore
+	attempts    attemptStore
+	unlocks     unlockStore
+	emailSender emailSender
+	// unlockURL is the client page links lead to, the token is added as a query parameter.
+	unlockURL string
+}
+
+func NewGuard(config Config, lockouts lockoutStore, attempts attemptStore, unlocks unlockStore,
+	emailSender emailSender, unlockURL string) Guard {
+	return Guard{
+		config:      config,
+		lockouts:    lockouts,
+		attempts:    attempts,
+		unlocks:     unlocks,
+		emailSender: emailSender,
+		unlockURL:   unlockURL,
+	}
+}
+
+// Attempt is a sign in reserved by Begin, it is ended by Failed or Succeeded once the password is checked.
+// An attempt never ended counts as failed.
+type Attempt struct {
+	id     int64
+	key    db.LockoutKey
+	inARow int
+}
+
+// Begin reserves a sign in with login from the IP address before its password is checked, userId is zero
+// for unknown logins. ErrIpBlocked, ErrLocked or ErrBackoff is returned with the time to wait when the
+// sign in must be refused.
+func (g Guard) Begin(ctx context.Context, userId int64, login, ipAddress string) (Attempt, time.Duration, error) {
+	login = normalizeLogin(login)
+	id, err := g.attempts.Insert(ctx, login, sql.NullInt64{Int64: userId, Valid: userId != 0}, ipAddress)
+	if err != nil {
+		return Attempt{}, 0, err
+	}
+	// The attempt is stored before the other ones are counted, of parallel attempts the last one counted sees all.
+	window := time.Duration(g.config.LockoutIpWindowMinutes) * time.Minute
+	logins, err := g.attempts.CountLoginsByIp(ctx, ipAddress, time.Now().Add(-window), id)
+	if err != nil {
+		return Attempt{}, 0, errors.Join(err, g.attempts.Delete(ctx, id))
+	}
+	if logins >= g.config.LockoutIpMaxLogins {
+		return Attempt{}, window, errors.Join(ErrIpBlocked, g.attempts.Delete(ctx, id))
+	}
+
+	key := db.LockoutKey{UserId: userId}
+	if userId == 0 {
+		key.Login = login
+	}
+	inARow, err := g.lockouts.Reserve(ctx, key, g.backoffUnit(), g.lockDuration(), g.threshold())
+	if errors.Is(err, sql.ErrNoRows) {
+		wait, refusal := g.refusal(ctx, key)
+		return Attempt{}, wait, errors.Join(refusal, g.attempts.Delete(ctx, id))
+	}
+	if err != nil {
+		return Attempt{}, 0, errors.Join(err, g.attempts.Delete(ctx, id))
+	}
+	return Attempt{id: id, key: key, inARow: inARow}, 0, nil
+}
+
+// refusal returns why the reservation for key was refused and the time to wait.
+func (g Guard) refusal(ctx context.Context, key db.LockoutKey) (time.Duration, error) {
+	lockout, err := g.lockouts.GetByKey(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if lockout.LockedUntil.Valid {
+		if wait, err := refuseUntil(now, lockout.LockedUntil.Time, ErrLocked); err != nil {
+			return wait, err
+		}
+	}
+	if wait, err := refuseUntil(now, lockout.NextAttemptAt, ErrBackoff); err != nil {
+		return wait, err
+	}
+	// The delay ended in between, the sign in is retried after a moment.
+	return time.Second, ErrBackoff
+}
+
+// refuseUntil returns err with the time to wait while until is ahead of now.
+func refuseUntil(now, until time.Time, err error) (time.Duration, error) {
+	if until.After(now) {
+		return until.Sub(now), err
+	}
+	return 0, nil
+}
+
+// Failed keeps the attempt as a failed sign in, user is the zero value for unknown logins. The attempt reaching
+// the threshold locks the account and emails the user an unlock link in the locale best matching the
+// Accept-Language value.
+func (g Guard) Failed(ctx context.Context, attempt Attempt, user db.User, locale string) error {
+	if attempt.inARow < g.threshold() {
+		return nil
+	}
+	if err := g.lockouts.Lock(ctx, attempt.key, time.Now().Add(g.lockDuration())); err != nil {
+		return err
+	}
+	if user.Id == 0 {
+		return nil
+	}
+	slog.WarnContext(ctx, fmt.Sprintf("user %d locked after %d failed sign ins", user.Id, attempt.inARow))
+	return g.sendUnlock(ctx, user, locale)
+}
+
+// Succeeded forgets the attempt and clears the failed sign ins of the user.
+func (g Guard) Succeeded(ctx context.Context, attempt Attempt) error {
+	if err := g.attempts.Delete(ctx, attempt.id); err != nil {
+		return err
+	}
+	if err := g.lockouts.Reset(ctx, attempt.key.UserId); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// Run deletes the attempts and lockouts the guard doesn't need anymore until ctx is done.
+func (g Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		if err := g.cleanup(ctx); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g Guard) cleanup(ctx context.Context) error {
+	before := time.Now().Add(-g.retention())
+	if err := g.attempts.DeleteBefore(ctx, before); err != nil {
+		return err
+	}
+	return g.lockouts.DeleteStale(ctx, before)
+}
+
+// backoffUnit is the wait after the first failed sign in, it doubles with every further one up to the lockout.
+func (g Guard) backoffUnit() time.Duration {
+	return time.Duration(g.config.LockoutBackoffSeconds) * time.Second
+}
+
+func (g Guard) threshold() int {
+	return max(g.config.LockoutThreshold, 1)
+}
+
+func (g Guard) lockDuration() time.Duration {
+	return time.Duration(g.config.LockoutMinutes) * time.Minute
+}
+
+// retention is how long failed sign ins and idle lockouts are kept.
+func (g Guard) retention() time.Duration {
+	window := time.Duration(g.config.LockoutIpWindowMinutes) * time.Minute
+	return max(attemptsRetention, window, g.lockDuration())
+}
+
+// normalizeLogin is the login failed sign ins are recorded with.
+func normalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+func (g Guard) sendUnlock(ctx context.Context, user db.User, locale string) error {
+	token, err := opaque.Generate()
+	if err != nil {
+		return err
+	}
+	if err := g.unlocks.Insert(ctx, db.AccountUnlock{
+		UserId:    user.Id,
+		TokenHash: opaque.Hash(token),
+		ExpiresAt: time.Now().Add(unlockDuration),
+	}); err != nil {
+		return err
+	}
+
+	link := g.unlockURL + "?" + url.Values{tokenQueryParam: {token}}.Encode()
+	if err := g.emailSender.SendTemplate(ctx, mailer.Email{
+		To:       user.Email,
+		Template: mailer.TemplateAccountUnlock,
+		Locale:   locale,
+		Data:     mailer.LinkData{Login: user.Login, Link: link},
+	}); err != nil {
+		return fmt.Errorf("send account unlock: %w", err)
+	}
+	return nil
+}
+
+// Unlock clears the lockout of the user the emailed link was sent to and returns the user id, the link works once.
+func (g Guard) Unlock(ctx context.Context, token string) (int64, error) {
+	return g.unlocks.Use(ctx, opaque.Hash(token))
+}
@@ -0,0 +1,365 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLockouts reserves like the lockouts upsert, the check and the delay happen under one lock.
+type testLockouts struct {
+	mu       sync.Mutex
+	lockouts map[db.LockoutKey]*db.Lockout
+}
+
+func (s *testLockouts) GetByKey(_ context.Context, key db.LockoutKey) (db.Lockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		return db.Lockout{}, sql.ErrNoRows
+	}
+	return *lockout, nil
+}
+
+func (s *testLockouts) Reserve(_ context.Context, key db.LockoutKey, backoff, lock time.Duration, threshold int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	lockout, ok := s.lockouts[key]
+	if !ok {
+		lockout = &db.Lockout{UserId: key.UserId, Login: key.Login}
+		s.lockouts[key] = lockout
+	} else if lockout.NextAttemptAt.After(now) || lockout.LockedUntil.Valid && lockout.LockedUntil.Time.After(now) {
+		return 0, fmt.Errorf("reserve sign in attempt: %w", sql.ErrNoRows)
+	}
+	lockout.FailedAttempts++
+	wait := lock
+	if lockout.FailedAttempts < threshold {
+		wait = min(backoff<<min(lockout.FailedAttempts-1, 30), lock)
+	}
+	lockout.NextAttemptAt = now.Add(wait)
+	return lockout.FailedAttempts, nil
+}
+
+func (s *testLockouts) Lock(_ context.Context, key db.LockoutKey, lockedUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lockouts[key].FailedAttempts = 0
+	s.lockouts[key].LockedUntil = sql.NullTime{Time: lockedUntil, Valid: true}
+	return nil
+}
+
+func (s *testLockouts) Reset(_ context.Context, userId int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := db.LockoutKey{UserId: userId}
+	if _, ok := s.lockouts[key]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.lockouts, key)
+	return nil
+}
+
+func (s *testLockouts) DeleteStale(context.Context, time.Time) error {
+	return nil
+}
+
+// elapse ends the delays of the key, as if the time to wait passed.
+func (s *testLockouts) elapse(key db.LockoutKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lockouts[key].NextAttemptAt = time.Now()
+}
+
+type testAttempt struct {
+	id        int64
+	login     string
+	ipAddress string
+}
+
+type testAttempts struct {
+	mu       sync.Mutex
+	lastId   int64
+	attempts []testAttempt
+}
+
+func (s *testAttempts) Insert(_ context.Context, login string, _ sql.NullInt64, ipAddress string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	s.attempts = append(s.attempts, testAttempt{id: s.lastId, login: login, ipAddress: ipAddress})
+	return s.lastId, nil
+}
+
+func (s *testAttempts) Delete(_ context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, attempt := range s.attempts {
+		if attempt.id == id {
+			s.attempts = append(s.attempts[:i], s.attempts[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *testAttempts) CountLoginsByIp(_ context.Context, ipAddress string, _ time.Time, exceptId int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	logins := map[string]bool{}
+	for _, attempt := range s.attempts {
+		if attempt.ipAddress == ipAddress && attempt.id != exceptId {
+			logins[attempt.login] = true
+		}
+	}
+	return len(logins), nil
+}
+
+func (s *testAttempts) DeleteBefore(context.Context, time.Time) error {
+	return nil
+}
+
+func (s *testAttempts) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.attempts)
+}
+
+type testUnlocks struct{}
+
+func (testUnlocks) Insert(context.Context, db.AccountUnlock) error {
+	return nil
+}
+
+func (testUnlocks) Use(context.Context, string) (int64, error) {
+	return 0, sql.ErrNoRows
+}
+
+type testEmails struct {
+	sent []mailer.Email
+}
+
+func (s *testEmails) SendTemplate(_ context.Context, email mailer.Email) error {
+	s.sent = append(s.sent, email)
+	return nil
+}
+
+type guardTest struct {
+	guard    Guard
+	lockouts *testLockouts
+	attempts *testAttempts
+	emails   *testEmails
+}
+
+func newGuardTest() guardTest {
+	test := guardTest{
+		lockouts: &testLockouts{lockouts: map[db.LockoutKey]*db.Lockout{}},
+		attempts: &testAttempts{},
+		emails:   &testEmails{},
+	}
+	test.guard = NewGuard(Config{
+		LockoutThreshold:       3,
+		LockoutMinutes:         30,
+		LockoutBackoffSeconds:  1,
+		LockoutIpMaxLogins:     5,
+		LockoutIpWindowMinutes: 15,
+	}, test.lockouts, test.attempts, testUnlocks{}, test.emails, "https://example.com/unlock")
+	return test
+}
+
+func TestGuardLocksAtThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      db.User
+		wantEmail bool
+	}{
+		{name: "user", user: db.User{Id: 1, Login: "alice", Email: "alice@example.com"}, wantEmail: true},
+		{name: "unknown login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			g := newGuardTest()
+			key := db.LockoutKey{UserId: tt.user.Id}
+			if tt.user.Id == 0 {
+				key.Login = "alice"
+			}
+
+			for i := range 3 {
+				attempt, _, err := g.guard.Begin(ctx, tt.user.Id, "Alice ", "10.0.0.1")
+				if err != nil {
+					t.Fatalf("attempt %d refused: %v", i+1, err)
+				}
+				// The next attempt waits for the outcome of this one.
+				if _, wait, err := g.guard.Begin(ctx, tt.user.Id, "alice", "10.0.0.1"); !errors.Is(err, ErrBackoff) || wait <= 0 {
+					t.Fatalf("attempt during attempt %d: wait %s err %v, want ErrBackoff", i+1, wait, err)
+				}
+				if err := g.guard.Failed(ctx, attempt, tt.user, "en"); err != nil {
+					t.Fatal(err)
+				}
+				g.lockouts.elapse(key)
+			}
+
+			_, wait, err := g.guard.Begin(ctx, tt.user.Id, "alice", "10.0.0.1")
+			if !errors.Is(err, ErrLocked) || wait < 29*time.Minute {
+				t.Errorf("wait %s err %v, want ErrLocked for 30m", wait, err)
+			}
+			if got := len(g.emails.sent) == 1; got != tt.wantEmail {
+				t.Errorf("%d unlock emails sent", len(g.emails.sent))
+			}
+			if got := g.attempts.count(); got != 3 {
+				t.Errorf("%d attempts kept, want the 3 failed", got)
+			}
+		})
+	}
+}
+
+func TestGuardBackoffDoubles(t *testing.T) {
+	ctx := context.Background()
+	g := newGuardTest()
+	g.guard.config.LockoutThreshold = 10
+	key := db.LockoutKey{UserId: 1}
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		attempt, _, err := g.guard.Begin(ctx, 1, "alice", "10.0.0.1")
+		if err != nil {
+			t.Fatalf("attempt %d refused: %v", i+1, err)
+		}
+		if err := g.guard.Failed(ctx, attempt, db.User{Id: 1}, "en"); err != nil {
+			t.Fatal(err)
+		}
+		_, wait, err := g.guard.Begin(ctx, 1, "alice", "10.0.0.1")
+		if !errors.Is(err, ErrBackoff) || wait > want || wait < want-time.Second {
+			t.Errorf("after failure %d: wait %s err %v, want ErrBackoff for %s", i+1, wait, err, want)
+		}
+		g.lockouts.elapse(key)
+	}
+}
+
+func TestGuardSucceededClearsFailures(t *testing.T) {
+	ctx := context.Background()
+	g := newGuardTest()
+	user := db.User{Id: 1, Login: "alice"}
+	key := db.LockoutKey{UserId: 1}
+
+	failed, _, err := g.guard.Begin(ctx, user.Id, user.Login, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.guard.Failed(ctx, failed, user, "en"); err != nil {
+		t.Fatal(err)
+	}
+	g.lockouts.elapse(key)
+	succeeded, _, err := g.guard.Begin(ctx, user.Id, user.Login, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.guard.Succeeded(ctx, succeeded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := g.lockouts.GetByKey(ctx, key); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("lockout kept: %v", err)
+	}
+	if got := g.attempts.count(); got != 1 {
+		t.Errorf("%d attempts kept, want the failed one", got)
+	}
+	if _, _, err := g.guard.Begin(ctx, user.Id, user.Login, "10.0.0.1"); err != nil {
+		t.Errorf("sign in after a success refused: %v", err)
+	}
+}
+
+func TestGuardParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	g := newGuardTest()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var passed, backoff int
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := g.guard.Begin(ctx, 1, "alice", "10.0.0.1")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				passed++
+			case errors.Is(err, ErrBackoff):
+				backoff++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if passed != 1 || backoff != 19 {
+		t.Errorf("%d attempts passed and %d delayed, want 1 and 19", passed, backoff)
+	}
+	if got := g.attempts.count(); got != 1 {
+		t.Errorf("%d attempts kept, want the reserved one", got)
+	}
+}
+
+func TestGuardIpVelocity(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("sequential", func(t *testing.T) {
+		g := newGuardTest()
+		for i := range 5 {
+			attempt, _, err := g.guard.Begin(ctx, 0, fmt.Sprintf("user%d", i), "10.0.0.1")
+			if err != nil {
+				t.Fatalf("login %d refused: %v", i, err)
+			}
+			if err := g.guard.Failed(ctx, attempt, db.User{}, "en"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, wait, err := g.guard.Begin(ctx, 0, "user5", "10.0.0.1")
+		if !errors.Is(err, ErrIpBlocked) || wait != 15*time.Minute {
+			t.Errorf("wait %s err %v, want ErrIpBlocked for 15m", wait, err)
+		}
+		if _, _, err := g.guard.Begin(ctx, 0, "user5", "10.0.0.2"); err != nil {
+			t.Errorf("other ip address refused: %v", err)
+		}
+	})
+
+	t.Run("parallel", func(t *testing.T) {
+		g := newGuardTest()
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var passed int
+		for i := range 30 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := g.guard.Begin(ctx, 0, fmt.Sprintf("user%d", i), "10.0.0.1")
+				if err != nil && !errors.Is(err, ErrIpBlocked) {
+					t.Error(err)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					passed++
+				}
+			}()
+		}
+		wg.Wait()
+
+		if passed == 0 || passed > 5 {
+			t.Errorf("%d logins passed, want 1 to 5", passed)
+		}
+		if got := g.attempts.count(); got != passed {
+			t.Errorf("%d attempts kept, want the %d passed", got, passed)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file to a directory, mail clients open them. It is meant for development.
+type FileMailer struct {
+	dir  string
+	from *mail.Address
+}
+
+func NewFileMailer(dir, from string) (FileMailer, error) {
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return FileMailer{}, fmt.Errorf("parse mail from: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return FileMailer{}, fmt.Errorf("create mail dir: %w", err)
+	}
+	return FileMailer{dir: dir, from: fromAddress}, nil
+}
+
+func (f FileMailer) Send(ctx context.Context, message Message) error {
+	now := time.Now()
+	data, err := message.encode(f.from, now)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(f.dir, now.Format("20060102T150405")+"-"+uuid.NewString()+".eml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("mail to %s written to %s", message.To, path))
+	return nil
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Mail drivers, log and file are meant for development.
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+type Config struct {
+	// MailDriver is smtp, file or log, file writes .eml files to MailDir.
+	MailDriver string `env:"MAIL_DRIVER, default=log"`
+	MailFrom   string `env:"MAIL_FROM, default=no-reply@localhost"`
+	MailDir    string `env:"MAIL_DIR, default=mail"`
+	// SmtpPort 465 connects with TLS, other ports upgrade with STARTTLS when the server offers it.
+	SmtpHost     string `env:"SMTP_HOST"`
+	SmtpPort     string `env:"SMTP_PORT, default=587"`
+	SmtpUsername string `env:"SMTP_USERNAME"`
+	SmtpPassword string `env:"SMTP_PASSWORD"`
+	// MailTemplatesDir replaces the embedded templates, it holds a directory of templates per locale.
+	MailTemplatesDir  string `env:"MAIL_TEMPLATES_DIR"`
+	MailDefaultLocale string `env:"MAIL_DEFAULT_LOCALE, default=en"`
+	// MailMaxAttempts is how many times the outbox tries to deliver a message before giving up.
+	MailMaxAttempts int `env:"MAIL_MAX_ATTEMPTS, default=8"`
+}
+
+// Message is an email, HTML is optional and sent as an alternative to Text.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// New returns the mailer of the configured driver.
+func New(config Config) (Mailer, error) {
+	switch config.MailDriver {
+	case DriverSMTP:
+		return NewSMTPMailer(config)
+	case DriverFile:
+		return NewFileMailer(config.MailDir, config.MailFrom)
+	case DriverLog:
+		return NewLogMailer(), nil
+	}
+	return nil, fmt.Errorf("unsupported mail driver %q", config.MailDriver)
+}
+
+// LogMailer writes messages to the log instead of sending them, it is meant for development.
+type LogMailer struct{}
+
+func NewLogMailer() LogMailer {
+	return LogMailer{}
+}
+
+func (LogMailer) Send(ctx context.Context, message Message) error {
+	slog.InfoContext(ctx, fmt.Sprintf("mail to %s: %s\n%s", message.To, message.Subject, message.Text))
+	return nil
+}
@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"log/slog"
+	"time"
+)
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 20
+	// outboxLease is longer than an SMTP attempt may take, see smtpTimeout.
+	outboxLease        = 2 * time.Minute
+	outboxRetryBackoff = 30 * time.Second
+	outboxMaxBackoff   = time.Hour
+)
+
+type outboxStore interface {
+	Insert(ctx context.Context, mail db.OutboxMail) error
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]db.OutboxMail, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, lastError string, nextAttemptAt sql.NullTime) error
+}
+
+// Outbox queues messages in Postgres and delivers them with the mailer in the background, a mail outage
+// delays messages instead of failing requests. Failed deliveries are retried with exponential backoff.
+type Outbox struct {
+	store       outboxStore
+	mailer      Mailer
+	maxAttempts int
+}
+
+func NewOutbox(store outboxStore, mailer Mailer, maxAttempts int) Outbox {
+	return Outbox{
+		store:       store,
+		mailer:      mailer,
+		maxAttempts: max(maxAttempts, 1),
+	}
+}
+
+// Send queues the message.
+func (o Outbox) Send(ctx context.Context, message Message) error {
+	return o.store.Insert(ctx, db.OutboxMail{
+		Recipient: message.To,
+		Subject:   message.Subject,
+		TextBody:  message.Text,
+		HtmlBody:  message.HTML,
+	})
+}
+
+// Run delivers queued messages until ctx is done.
+func (o Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := o.deliver(ctx); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends the due messages, batch after batch until none is due.
+func (o Outbox) deliver(ctx context.Context) error {
+	for {
+		mails, err := o.store.ClaimDue(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			return err
+		}
+		for _, mail := range mails {
+			o.attempt(ctx, mail)
+		}
+		if len(mails) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+func (o Outbox) attempt(ctx context.Context, mail db.OutboxMail) {
+	err := o.mailer.Send(ctx, Message{
+		To:      mail.Recipient,
+		Subject: mail.Subject,
+		Text:    mail.TextBody,
+		HTML:    mail.HtmlBody,
+	})
+	if err == nil {
+		if err := o.store.MarkSent(ctx, mail.Id); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+		}
+		return
+	}
+
+	var nextAttemptAt sql.NullTime
+	if mail.Attempts < o.maxAttempts {
+		nextAttemptAt = sql.NullTime{Time: time.Now().Add(retryBackoff(mail.Attempts)), Valid: true}
+		slog.ErrorContext(ctx, fmt.Sprintf("mail %d attempt %d failed, retrying at %s: %s",
+			mail.Id, mail.Attempts, nextAttemptAt.Time.Format(time.RFC3339), err.Error()))
+	} else {
+		slog.ErrorContext(ctx, fmt.Sprintf("mail %d given up after %d attempts: %s", mail.Id, mail.Attempts, err.Error()))
+	}
+	if err := o.store.MarkFailed(ctx, mail.Id, err.Error(), nextAttemptAt); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
+
+// retryBackoff doubles the wait after every attempt, up to outboxMaxBackoff.
+func retryBackoff(attempts int) time.Duration {
+	backoff := outboxRetryBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
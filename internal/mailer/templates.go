@@ -0,0 +1,225 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Templates of the emails, each locale directory holds name.txt defining the "subject" template next to
+// the text body and optionally name.html.
+const (
+	TemplateInvitation        = "invitation"
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+	TemplateAccountUnlock     = "account_unlock"
+)
+
+const subjectTemplate = "subject"
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// InvitationData fills TemplateInvitation.
+type InvitationData struct {
+	Inviter      string
+	Organization string
+	Role         string
+	Link         string
+}
+
+// LinkData fills templates sending the user a link, like TemplatePasswordReset and TemplateAccountUnlock.
+type LinkData struct {
+	Login string
+	Link  string
+}
+
+// Email is a message rendered from Template with Data, in the locale best matching Locale,
+// an Accept-Language header value.
+type Email struct {
+	To       string
+	Template string
+	Locale   string
+	Data     any
+}
+
+type localeTemplates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+type Templates struct {
+	defaultLocale string
+	locales       map[string]localeTemplates
+}
+
+// LoadTemplates parses the embedded templates or those of MailTemplatesDir, the default locale must have them all.
+func LoadTemplates(config Config) (Templates, error) {
+	var templatesFS fs.FS
+	if config.MailTemplatesDir != "" {
+		templatesFS = os.DirFS(config.MailTemplatesDir)
+	} else {
+		sub, err := fs.Sub(embeddedTemplates, "templates")
+		if err != nil {
+			return Templates{}, fmt.Errorf("open embedded templates: %w", err)
+		}
+		templatesFS = sub
+	}
+
+	entries, err := fs.ReadDir(templatesFS, ".")
+	if err != nil {
+		return Templates{}, fmt.Errorf("read templates: %w", err)
+	}
+	templates := Templates{
+		defaultLocale: strings.ToLower(config.MailDefaultLocale),
+		locales:       map[string]localeTemplates{},
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		locale, err := loadLocale(templatesFS, entry.Name())
+		if err != nil {
+			return Templates{}, err
+		}
+		templates.locales[strings.ToLower(entry.Name())] = locale
+	}
+
+	defaults, ok := templates.locales[templates.defaultLocale]
+	if !ok {
+		return Templates{}, fmt.Errorf("no templates for the default locale %q", config.MailDefaultLocale)
+	}
+	for _, name := range []string{TemplateInvitation, TemplatePasswordReset, TemplateEmailVerification, TemplateAccountUnlock} {
+		if _, ok := defaults.text[name]; !ok {
+			return Templates{}, fmt.Errorf("template %s missing in the default locale", name)
+		}
+	}
+	return templates, nil
+}
+
+func loadLocale(templatesFS fs.FS, dir string) (localeTemplates, error) {
+	locale := localeTemplates{
+		text: map[string]*texttemplate.Template{},
+		html: map[string]*htmltemplate.Template{},
+	}
+	entries, err := fs.ReadDir(templatesFS, dir)
+	if err != nil {
+		return localeTemplates{}, fmt.Errorf("read %s templates: %w", dir, err)
+	}
+	for _, entry := range entries {
+		file := path.Join(dir, entry.Name())
+		name, ext, _ := strings.Cut(entry.Name(), ".")
+		switch ext {
+		case "txt":
+			tmpl, err := texttemplate.ParseFS(templatesFS, file)
+			if err != nil {
+				return localeTemplates{}, fmt.Errorf("parse template %s: %w", file, err)
+			}
+			if tmpl.Lookup(subjectTemplate) == nil {
+				return localeTemplates{}, fmt.Errorf("template %s doesn't define the subject", file)
+			}
+			locale.text[name] = tmpl
+		case "html":
+			tmpl, err := htmltemplate.ParseFS(templatesFS, file)
+			if err != nil {
+				return localeTemplates{}, fmt.Errorf("parse template %s: %w", file, err)
+			}
+			locale.html[name] = tmpl
+		}
+	}
+	return locale, nil
+}
+
+// Render renders the email to a message, the text and HTML templates come from the same locale.
+func (t Templates) Render(email Email) (Message, error) {
+	locale := t.locales[t.matchLocale(email.Template, email.Locale)]
+	textTemplate := locale.text[email.Template]
+	if textTemplate == nil {
+		return Message{}, fmt.Errorf("template %s not found", email.Template)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := textTemplate.ExecuteTemplate(&subject, subjectTemplate, email.Data); err != nil {
+		return Message{}, fmt.Errorf("render %s subject: %w", email.Template, err)
+	}
+	if err := textTemplate.Execute(&text, email.Data); err != nil {
+		return Message{}, fmt.Errorf("render %s text: %w", email.Template, err)
+	}
+	if htmlTemplate := locale.html[email.Template]; htmlTemplate != nil {
+		if err := htmlTemplate.Execute(&html, email.Data); err != nil {
+			return Message{}, fmt.Errorf("render %s html: %w", email.Template, err)
+		}
+	}
+	return Message{
+		To:      email.To,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// matchLocale returns the locale having the template which best matches the Accept-Language value,
+// a language falls back to its base, de-AT to de, and nothing matching to the default locale.
+func (t Templates) matchLocale(name, acceptLanguage string) string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+	var languages []language
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if tag != "" && tag != "*" && quality > 0 {
+			languages = append(languages, language{tag: strings.ToLower(tag), quality: quality})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool {
+		return languages[i].quality > languages[j].quality
+	})
+
+	for _, language := range languages {
+		base, _, _ := strings.Cut(language.tag, "-")
+		for _, candidate := range slices.Compact([]string{language.tag, base}) {
+			if _, ok := t.locales[candidate].text[name]; ok {
+				return candidate
+			}
+		}
+	}
+	return t.defaultLocale
+}
+
+// TemplateMailer renders emails from the templates and sends them with the mailer.
+type TemplateMailer struct {
+	templates Templates
+	mailer    Mailer
+}
+
+func NewTemplateMailer(templates Templates, mailer Mailer) TemplateMailer {
+	return TemplateMailer{
+		templates: templates,
+		mailer:    mailer,
+	}
+}
+
+func (t TemplateMailer) SendTemplate(ctx context.Context, email Email) error {
+	message, err := t.templates.Render(email)
+	if err != nil {
+		return err
+	}
+	return t.mailer.Send(ctx, message)
+}
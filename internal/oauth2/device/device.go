@@ -0,0 +1,44 @@
+package device
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// userCodeAlphabet has no vowels, so codes don't spell words, and no characters that look alike (RFC 8628 6.1).
+const (
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// NewUserCode returns a random code for the user to type in, formatted as XXXX-XXXX.
+func NewUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("read random number: %w", err)
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:userCodeLength/2]) + "-" + string(code[userCodeLength/2:]), nil
+}
+
+// NormalizeUserCode brings a code typed by the user to the NewUserCode format,
+// case and separators are ignored.
+func NormalizeUserCode(code string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			normalized.WriteRune(r)
+		}
+	}
+	if normalized.Len() != userCodeLength {
+		return normalized.String()
+	}
+	s := normalized.String()
+	return s[:userCodeLength/2] + "-" + s[userCodeLength/2:]
+}
@@ -0,0 +1,51 @@
+package device
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNewUserCode(t *testing.T) {
+	format := regexp.MustCompile(`^[` + userCodeAlphabet + `]{4}-[` + userCodeAlphabet + `]{4}$`)
+	seen := map[string]bool{}
+	for range 100 {
+		code, err := NewUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("code %q doesn't match the format", code)
+		}
+		if NormalizeUserCode(code) != code {
+			t.Fatalf("code %q changes when normalized", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 99 {
+		t.Errorf("%d distinct codes out of 100", len(seen))
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{name: "formatted", code: "BCDF-GHJK", want: "BCDF-GHJK"},
+		{name: "lowercase", code: "bcdf-ghjk", want: "BCDF-GHJK"},
+		{name: "no separator", code: "BCDFGHJK", want: "BCDF-GHJK"},
+		{name: "spaces", code: " BCDF GHJK ", want: "BCDF-GHJK"},
+		{name: "vowels dropped", code: "BCDF-GHJA", want: "BCDFGHJ"},
+		{name: "too short", code: "BCD-FGH", want: "BCDFGH"},
+		{name: "too long", code: "BCDF-GHJK-L", want: "BCDFGHJKL"},
+		{name: "empty", code: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeUserCode(tt.code); got != tt.want {
+				t.Errorf("NormalizeUserCode(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package pkce
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// MethodS256 is the only supported code challenge method, plain is rejected.
+const MethodS256 = "S256"
+
+// RFC 7636: 43-128 characters of [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~".
+var verifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// ValidChallenge reports whether challenge is a base64url encoded SHA-256 digest.
+func ValidChallenge(challenge, method string) bool {
+	if method != MethodS256 {
+		return false
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// Verify checks the code verifier against the challenge sent to the authorize endpoint.
+func Verify(verifier, challenge, method string) bool {
+	if method != MethodS256 || !verifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,64 @@
+package pkce
+
+import (
+	"strings"
+	"testing"
+)
+
+// The RFC 7636 appendix B example.
+const (
+	exampleVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	exampleChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestValidChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		want      bool
+	}{
+		{name: "s256", challenge: exampleChallenge, method: MethodS256, want: true},
+		{name: "plain method", challenge: exampleChallenge, method: "plain"},
+		{name: "no method", challenge: exampleChallenge},
+		{name: "lowercase method", challenge: exampleChallenge, method: "s256"},
+		{name: "empty challenge", method: MethodS256},
+		{name: "short digest", challenge: exampleChallenge[:40], method: MethodS256},
+		{name: "padded", challenge: exampleChallenge + "=", method: MethodS256},
+		{name: "standard base64", challenge: strings.ReplaceAll(exampleChallenge, "-", "+"), method: MethodS256},
+		{name: "verifier as challenge", challenge: exampleVerifier + "a", method: MethodS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidChallenge(tt.challenge, tt.method); got != tt.want {
+				t.Errorf("ValidChallenge(%q, %q) = %v, want %v", tt.challenge, tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		want      bool
+	}{
+		{name: "rfc example", verifier: exampleVerifier, challenge: exampleChallenge, method: MethodS256, want: true},
+		{name: "other verifier", verifier: strings.Repeat("a", 43), challenge: exampleChallenge, method: MethodS256},
+		{name: "plain method", verifier: exampleVerifier, challenge: exampleVerifier, method: "plain"},
+		{name: "empty verifier", challenge: exampleChallenge, method: MethodS256},
+		{name: "verifier too short", verifier: exampleVerifier[:42], challenge: exampleChallenge, method: MethodS256},
+		{name: "verifier too long", verifier: strings.Repeat("a", 129), challenge: exampleChallenge, method: MethodS256},
+		{name: "verifier with invalid character", verifier: exampleVerifier[:42] + "+", challenge: exampleChallenge, method: MethodS256},
+		{name: "empty challenge", verifier: exampleVerifier, method: MethodS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Verify(tt.verifier, tt.challenge, tt.method); got != tt.want {
+				t.Errorf("Verify(%q, %q, %q) = %v, want %v", tt.verifier, tt.challenge, tt.method, got, tt.want)
+			}
+		})
+	}
+}
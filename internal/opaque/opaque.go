@@ -0,0 +1,26 @@
+package opaque
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+const tokenBytes = 32
+
+// Generate returns a random url-safe token, only its Hash should be stored.
+func Generate() (string, error) {
+	data := make([]byte, tokenBytes)
+	if _, err := rand.Read(data); err != nil {
+		return "", fmt.Errorf("read random bytes: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Hash returns the representation of a token kept in storage.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sha1HexLength   = 40
+	rangePrefixSize = 5
+	// maxLineLength bounds a corpus line, a 40 characters hash, a colon and the count.
+	maxLineLength = 64
+)
+
+// Corpus looks passwords up in a Pwned Passwords (HIBP) download, lines are the upper case SHA-1 and the
+// breach count separated by a colon. The path is either a single file sorted by hash, searched on disk,
+// or a directory of range files named after the first 5 characters of the hash and holding the remaining 35.
+type Corpus struct {
+	path    string
+	isRange bool
+}
+
+func OpenCorpus(path string) (Corpus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Corpus{}, fmt.Errorf("open breached passwords: %w", err)
+	}
+	return Corpus{path: path, isRange: info.IsDir()}, nil
+}
+
+// Contains reports whether the password is in the corpus.
+func (c Corpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if c.isRange {
+		return c.rangeContains(hash)
+	}
+	return c.fileContains(hash)
+}
+
+func (c Corpus) rangeContains(hash string) (bool, error) {
+	file, err := os.Open(filepath.Join(c.path, hash[:rangePrefixSize]+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("open range file: %w", err)
+	}
+	defer file.Close()
+
+	suffix := []byte(hash[rangePrefixSize:])
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if bytes.EqualFold(lineHash(scanner.Bytes()), suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read range file: %w", err)
+	}
+	return false, nil
+}
+
+// fileContains binary searches the sorted file, a line is found by reading around the middle offset.
+func (c Corpus) fileContains(hash string) (bool, error) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return false, fmt.Errorf("open breached passwords: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat breached passwords: %w", err)
+	}
+
+	target := []byte(hash)
+	low, high := int64(0), info.Size()
+	for low < high {
+		start, line, err := lineAt(file, (low+high)/2, low)
+		if err != nil {
+			return false, err
+		}
+		switch bytes.Compare(bytes.ToUpper(lineHash(line)), target) {
+		case 0:
+			return true, nil
+		case -1:
+			low = start + int64(len(line)) + 1
+		default:
+			high = start
+		}
+	}
+	return false, nil
+}
+
+// lineAt returns the line containing offset and its start, the line starts at low or later.
+func lineAt(file *os.File, offset, low int64) (int64, []byte, error) {
+	from := max(offset-maxLineLength, low)
+	buf := make([]byte, offset-from+maxLineLength)
+	n, err := file.ReadAt(buf, from)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, nil, fmt.Errorf("read breached passwords: %w", err)
+	}
+	buf = buf[:n]
+
+	position := int(offset - from)
+	start := bytes.LastIndexByte(buf[:position], '\n') + 1
+	end := bytes.IndexByte(buf[start:], '\n')
+	if end < 0 {
+		end = len(buf) - start
+	}
+	return from + int64(start), bytes.TrimRight(buf[start:start+end], "\r"), nil
+}
+
+func lineHash(line []byte) []byte {
+	hash, _, _ := bytes.Cut(line, []byte(":"))
+	return hash
+}
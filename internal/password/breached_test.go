@@ -0,0 +1,202 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeCorpus(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sortedLines returns the corpus lines of the passwords sorted by hash.
+func sortedLines(passwords ...string) []string {
+	var lines []string
+	for i, password := range passwords {
+		lines = append(lines, sha1Hex(password)+":"+strconv.Itoa(i*7919+1))
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func TestLineAt(t *testing.T) {
+	const content = "AAA:1\nBBB:22\r\nCCC:333"
+	file, err := os.Open(writeCorpus(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		name      string
+		offset    int64
+		low       int64
+		wantStart int64
+		wantLine  string
+	}{
+		{name: "file start", offset: 0, wantStart: 0, wantLine: "AAA:1"},
+		{name: "first line middle", offset: 3, wantStart: 0, wantLine: "AAA:1"},
+		{name: "first line newline", offset: 5, wantStart: 0, wantLine: "AAA:1"},
+		{name: "second line start", offset: 6, wantStart: 6, wantLine: "BBB:22"},
+		{name: "carriage return", offset: 12, wantStart: 6, wantLine: "BBB:22"},
+		{name: "last line without newline", offset: int64(len(content)) - 1, wantStart: 14, wantLine: "CCC:333"},
+		{name: "low inside the line", offset: 9, low: 8, wantStart: 8, wantLine: "B:22"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, line, err := lineAt(file, tt.offset, tt.low)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart || string(line) != tt.wantLine {
+				t.Errorf("lineAt(%d, %d) = %d %q, want %d %q", tt.offset, tt.low, start, line, tt.wantStart, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestFileContains(t *testing.T) {
+	lines := sortedLines("password", "123456", "qwerty", "letmein", "dragon", "monkey", "football")
+	first, last := strings.Split(lines[0], ":")[0], strings.Split(lines[len(lines)-1], ":")[0]
+	sorted := strings.Join(lines, "\n") + "\n"
+
+	tests := []struct {
+		name    string
+		content string
+		hash    string
+		want    bool
+	}{
+		{name: "first line", content: sorted, hash: first, want: true},
+		{name: "last line", content: sorted, hash: last, want: true},
+		{name: "middle line", content: sorted, hash: sha1Hex("qwerty"), want: true},
+		{name: "missing", content: sorted, hash: sha1Hex("correct horse battery staple")},
+		{name: "missing before the first line", content: sorted, hash: strings.Repeat("0", sha1HexLength)},
+		{name: "missing after the last line", content: sorted, hash: strings.Repeat("F", sha1HexLength)},
+		{name: "prefix of a hash", content: sorted, hash: first[:sha1HexLength-1]},
+		{name: "last line without newline", content: strings.TrimSuffix(sorted, "\n"), hash: last, want: true},
+		{name: "crlf", content: strings.Join(lines, "\r\n") + "\r\n", hash: last, want: true},
+		{name: "crlf middle line", content: strings.Join(lines, "\r\n") + "\r\n", hash: sha1Hex("qwerty"), want: true},
+		{name: "lower case hashes", content: strings.ToLower(sorted), hash: sha1Hex("qwerty"), want: true},
+		{name: "single line", content: lines[0] + "\n", hash: first, want: true},
+		{name: "single line missing", content: lines[0] + "\n", hash: last},
+		{name: "empty file", content: "", hash: first},
+		{name: "blank lines only", content: "\n\n\n", hash: first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corpus, err := OpenCorpus(writeCorpus(t, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := corpus.fileContains(tt.hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("fileContains(%s) = %v, want %v", tt.hash, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFileContainsEveryLine searches every line of a bigger corpus, so each one is hit from
+// both sides of the binary search.
+func TestFileContainsEveryLine(t *testing.T) {
+	var passwords []string
+	for i := range 500 {
+		passwords = append(passwords, strings.Repeat("x", i%7)+string(rune('a'+i%26))+strings.Repeat("1", i%11)+string(rune('A'+i/26)))
+	}
+	lines := sortedLines(passwords...)
+	corpus, err := OpenCorpus(writeCorpus(t, strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range passwords {
+		found, err := corpus.Contains(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Errorf("%q not found", password)
+		}
+	}
+}
+
+// TestFileContainsUnsorted documents that an unsorted file misses hashes, the search ends without an error.
+func TestFileContainsUnsorted(t *testing.T) {
+	lines := sortedLines("password", "123456", "qwerty", "letmein", "dragon", "monkey", "football")
+	slices.Reverse(lines)
+	corpus, err := OpenCorpus(writeCorpus(t, strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found int
+	for _, line := range lines {
+		ok, err := corpus.fileContains(strings.Split(line, ":")[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			found++
+		}
+	}
+	if found == len(lines) {
+		t.Error("every hash of the reversed file found")
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("password")
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + strings.ToLower(hash[rangePrefixSize:]) + ":9545824\r\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:rangePrefixSize]+".txt"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := OpenCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     bool
+	}{
+		{name: "in the range file", password: "password", want: true},
+		{name: "range file without the hash", password: "Password"},
+		{name: "no range file", password: "correct horse battery staple"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := corpus.Contains(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenCorpusMissing(t *testing.T) {
+	if _, err := OpenCorpus(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("opened a missing corpus")
+	}
+}
@@ -0,0 +1,172 @@
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules a password is checked against, they name the violations.
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleStrength     = "strength"
+	RulePersonalInfo = "personal_info"
+	RuleBreached     = "breached"
+)
+
+// minPersonalInfoLength keeps short logins from banning every password containing a couple of common letters.
+const minPersonalInfoLength = 3
+
+type Config struct {
+	PasswordMinLength int `env:"PASSWORD_MIN_LENGTH, default=8"`
+	PasswordMaxLength int `env:"PASSWORD_MAX_LENGTH, default=72"`
+	// PasswordMinEntropy is the minimum estimated strength in bits, see EntropyBits.
+	PasswordMinEntropy float64 `env:"PASSWORD_MIN_ENTROPY, default=40"`
+	// PasswordBreachedPath is a Pwned Passwords SHA-1 file or range directory, the check is off when empty.
+	PasswordBreachedPath string `env:"PASSWORD_BREACHED_PATH"`
+}
+
+// Violation is a broken rule of the policy with a message for the user.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+type Policy struct {
+	minLength  int
+	maxLength  int
+	maxBytes   int
+	minEntropy float64
+	breached   *Corpus
+}
+
+// NewPolicy builds the policy, maxBytes is the password limit of the hash algorithm in bytes, 0 when there is none.
+// Lengths of the config count characters, which take up to 4 bytes each.
+func NewPolicy(config Config, maxBytes int) (Policy, error) {
+	if config.PasswordMinLength < 1 || config.PasswordMaxLength < config.PasswordMinLength {
+		return Policy{}, fmt.Errorf("password length limits %d-%d not valid", config.PasswordMinLength, config.PasswordMaxLength)
+	}
+	policy := Policy{
+		minLength:  config.PasswordMinLength,
+		maxLength:  config.PasswordMaxLength,
+		maxBytes:   maxBytes,
+		minEntropy: config.PasswordMinEntropy,
+	}
+	if config.PasswordBreachedPath != "" {
+		corpus, err := OpenCorpus(config.PasswordBreachedPath)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.breached = &corpus
+	}
+	return policy, nil
+}
+
+// Check returns the rules the password violates, personal is what the password must not contain,
+// like the login and the email of the user.
+func (p Policy) Check(password string, personal ...string) ([]Violation, error) {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.minLength),
+		})
+	}
+	switch {
+	case length > p.maxLength:
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("password must be at most %d characters long", p.maxLength),
+		})
+	case p.maxBytes > 0 && len(password) > p.maxBytes:
+		violations = append(violations, Violation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("password must be at most %d bytes long, accented letters and symbols take several", p.maxBytes),
+		})
+	}
+	if EntropyBits(password) < p.minEntropy {
+		violations = append(violations, Violation{
+			Rule:    RuleStrength,
+			Message: "password is too weak, make it longer or mix letters, digits and symbols",
+		})
+	}
+	if containsPersonalInfo(password, personal) {
+		violations = append(violations, Violation{
+			Rule:    RulePersonalInfo,
+			Message: "password must not contain the login or email",
+		})
+	}
+
+	if p.breached != nil && password != "" {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			return nil, fmt.Errorf("check breached passwords: %w", err)
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Rule:    RuleBreached,
+				Message: "password appeared in a data breach, choose another one",
+			})
+		}
+	}
+	return violations, nil
+}
+
+// EntropyBits estimates the password strength from the character classes it uses and its length.
+// Repeated characters and runs of consecutive ones, like aaa or 1234, count as a single character.
+func EntropyBits(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	var effectiveLength int
+	var previous rune = -1
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+		if r != previous && r != previous+1 && r != previous-1 {
+			effectiveLength++
+		}
+		previous = r
+	}
+
+	var pool int
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(effectiveLength) * math.Log2(float64(pool))
+}
+
+func containsPersonalInfo(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, info := range personal {
+		info = strings.ToLower(info)
+		// The local part of an email is what people put in passwords.
+		if local, _, ok := strings.Cut(info, "@"); ok {
+			info = local
+		}
+		if len(info) >= minPersonalInfoLength && strings.Contains(password, info) {
+			return true
+		}
+	}
+	return false
+}
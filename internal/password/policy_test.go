@@ -0,0 +1,100 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicyLength(t *testing.T) {
+	config := Config{PasswordMinLength: 8, PasswordMaxLength: 72}
+	tests := []struct {
+		name        string
+		maxLength   int
+		maxBytes    int
+		password    string
+		wantMessage string
+	}{
+		{name: "ascii at the limit", maxBytes: 72, password: strings.Repeat("a", 72)},
+		{
+			name:        "ascii above the limit",
+			maxBytes:    72,
+			password:    strings.Repeat("a", 73),
+			wantMessage: "password must be at most 72 characters long",
+		},
+		{name: "multibyte at the byte limit", maxBytes: 72, password: strings.Repeat("é", 36)},
+		{
+			name:        "multibyte above the byte limit",
+			maxBytes:    72,
+			password:    strings.Repeat("é", 37),
+			wantMessage: "password must be at most 72 bytes long, accented letters and symbols take several",
+		},
+		{
+			name:        "emoji above the byte limit",
+			maxBytes:    72,
+			password:    strings.Repeat("🔑", 19),
+			wantMessage: "password must be at most 72 bytes long, accented letters and symbols take several",
+		},
+		{name: "multibyte without a byte limit", password: strings.Repeat("é", 72)},
+		{
+			name:        "both limits exceeded",
+			maxBytes:    72,
+			password:    strings.Repeat("é", 73),
+			wantMessage: "password must be at most 72 characters long",
+		},
+		{
+			name:        "characters limit below the byte limit",
+			maxLength:   16,
+			maxBytes:    72,
+			password:    strings.Repeat("é", 17),
+			wantMessage: "password must be at most 16 characters long",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := config
+			if tt.maxLength > 0 {
+				config.PasswordMaxLength = tt.maxLength
+			}
+			policy, err := NewPolicy(config, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			violations, err := policy.Check(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var messages []string
+			for _, violation := range violations {
+				if violation.Rule == RuleMaxLength {
+					messages = append(messages, violation.Message)
+				}
+			}
+			switch {
+			case tt.wantMessage == "" && len(messages) > 0:
+				t.Errorf("violations %v, want none", messages)
+			case tt.wantMessage != "" && (len(messages) != 1 || messages[0] != tt.wantMessage):
+				t.Errorf("violations %v, want %q", messages, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "valid", config: Config{PasswordMinLength: 8, PasswordMaxLength: 72}},
+		{name: "no minimum", config: Config{PasswordMaxLength: 72}, wantErr: true},
+		{name: "maximum below minimum", config: Config{PasswordMinLength: 8, PasswordMaxLength: 7}, wantErr: true},
+		{name: "missing breached corpus", config: Config{PasswordMinLength: 8, PasswordMaxLength: 72, PasswordBreachedPath: "/nonexistent"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPolicy(tt.config, 72); (err != nil) != tt.wantErr {
+				t.Errorf("err %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
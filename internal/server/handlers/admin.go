@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type (
+	roleStore interface {
+		List(ctx context.Context) ([]db.Role, error)
+		GetUserRoles(ctx context.Context, userId int64) ([]string, error)
+		Assign(ctx context.Context, userId int64, role string) error
+		Unassign(ctx context.Context, userId int64, role string) error
+	}
+	sessionRevoker interface {
+		LogoutEverywhere(ctx context.Context, userId int64) error
+	}
+)
+
+// AdminHandler manages role assignments, it is routed behind RequirePermission(db.PermissionRolesManage).
+type AdminHandler struct {
+	roleStore      roleStore
+	sessionRevoker sessionRevoker
+}
+
+func NewAdminHandler(roleStore roleStore, sessionRevoker sessionRevoker) AdminHandler {
+	return AdminHandler{
+		roleStore:      roleStore,
+		sessionRevoker: sessionRevoker,
+	}
+}
+
+func (h AdminHandler) ListRoles(c fiber.Ctx) error {
+	ctx := c.Context()
+	roles, err := h.roleStore.List(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	response := make([]responses.Role, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, responses.Role{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+func (h AdminHandler) GetUserRoles(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return badUserId(c)
+	}
+
+	roles, err := h.roleStore.GetUserRoles(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(responses.UserRoles{
+		UserId: userId,
+		Roles:  roles,
+	})
+}
+
+// AssignRole gives the role to the user, it is in the user's tokens from the next sign in or refresh.
+func (h AdminHandler) AssignRole(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return badUserId(c)
+	}
+
+	if err := h.roleStore.Assign(ctx, userId, c.Params("role")); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+				Code:    http.StatusNotFound,
+				Message: "user or role not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "role not assigned",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// UnassignRole takes the role from the user and signs the user out everywhere,
+// so tokens still carrying the role stop working at once.
+func (h AdminHandler) UnassignRole(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return badUserId(c)
+	}
+	role := c.Params("role")
+
+	adminId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+	if adminId == userId && role == db.RoleAdmin {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "admins can't remove their own admin role",
+		})
+	}
+
+	if err := h.roleStore.Unassign(ctx, userId, role); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+				Code:    http.StatusNotFound,
+				Message: "role not assigned to the user",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "role not unassigned",
+		})
+	}
+	if err := h.sessionRevoker.LogoutEverywhere(ctx, userId); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "user tokens not revoked",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+func badUserId(c fiber.Ctx) error {
+	return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: "user id is not valid",
+	})
+}
@@ -5,30 +5,52 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/antlko/goauth-boilerplate/internal/db"
 	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/password"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
 	"github.com/antlko/goauth-boilerplate/internal/server/requests"
 	"github.com/antlko/goauth-boilerplate/internal/server/responses"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/oauth2"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
 type (
 	userInserter interface {
-		Insert(ctx context.Context, user db.User) error
+		Insert(ctx context.Context, user db.User) (int64, error)
 	}
 	userGetter interface {
 		GetByLoginOrEmail(ctx context.Context, login, email string) (db.User, error)
 		GetByLogin(ctx context.Context, login string) (db.User, error)
+		GetByEmail(ctx context.Context, email string) (db.User, error)
+	}
+	passwordUpdater interface {
+		UpdatePassword(ctx context.Context, id int64, password string) error
+	}
+	verificationSender interface {
+		Send(ctx context.Context, user db.User, locale string) (time.Duration, error)
+	}
+	passwordHasher interface {
+		Hash(password string) (string, error)
+		Verify(encoded, password string) (bool, error)
+		NeedsRehash(encoded string) bool
+	}
+	passwordPolicy interface {
+		Check(password string, personal ...string) ([]password.Violation, error)
 	}
 	authorizer interface {
-		CreateTokens(username string) (jwt.Tokens, error)
-		ValidateAndUpdate(refresh string) (jwt.Tokens, error)
-		Validate(token string) (bool, string, error)
+		CreateTokens(ctx context.Context, userId int64, auth jwt.Authentication) (jwt.Tokens, error)
+		CreateStateToken(scope []string, nonce string) (string, error)
+		ValidateAndUpdate(ctx context.Context, refresh, clientId string) (jwt.Tokens, error)
+		ValidateState(token string) (jwt.Claims, error)
+		Logout(ctx context.Context, claims jwt.Claims, refreshToken string) error
+		LogoutEverywhere(ctx context.Context, userId int64) error
+		GrantScopes(requested string) []string
 	}
 	googleAuthorizer interface {
 		Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
@@ -40,23 +62,41 @@ type (
 type AuthHandler struct {
 	userInserter     userInserter
 	userGetter       userGetter
+	passwordUpdater  passwordUpdater
+	passwordHasher   passwordHasher
+	passwordPolicy   passwordPolicy
+	verifications    verificationSender
+	signInGuard      signInGuard
 	authorizer       authorizer
 	googleAuthorizer googleAuthorizer
+	cookies          middlewares.TokenCookies
 	clientURL        string
 }
 
 func NewAuthHandler(
 	userInserter userInserter,
 	userGetter userGetter,
+	passwordUpdater passwordUpdater,
+	passwordHasher passwordHasher,
+	passwordPolicy passwordPolicy,
+	verifications verificationSender,
+	signInGuard signInGuard,
 	authorizer authorizer,
 	googleConfig googleAuthorizer,
+	cookies middlewares.TokenCookies,
 	clientURL string,
 ) AuthHandler {
 	return AuthHandler{
 		userInserter:     userInserter,
 		userGetter:       userGetter,
+		passwordUpdater:  passwordUpdater,
+		passwordHasher:   passwordHasher,
+		passwordPolicy:   passwordPolicy,
+		verifications:    verifications,
+		signInGuard:      signInGuard,
 		authorizer:       authorizer,
 		googleAuthorizer: googleConfig,
+		cookies:          cookies,
 		clientURL:        clientURL,
 	}
 }
@@ -88,7 +128,10 @@ func (a AuthHandler) SignUp(c fiber.Ctx) error {
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), 8)
+	if ok, err := checkPassword(c, a.passwordPolicy, request.Password, request.Login, request.Email); !ok {
+		return err
+	}
+	hashedPassword, err := a.passwordHasher.Hash(request.Password)
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -97,17 +140,23 @@ func (a AuthHandler) SignUp(c fiber.Ctx) error {
 		})
 	}
 
-	if err := a.userInserter.Insert(ctx, db.User{
+	user = db.User{
 		Login:    request.Login,
 		Email:    request.Email,
-		Password: string(hashedPassword),
-	}); err != nil {
+		Password: hashedPassword,
+	}
+	user.Id, err = a.userInserter.Insert(ctx, user)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "user not saved",
 		})
 	}
+	// The user is signed up already, a lost verification email is sent again on request.
+	if _, err := a.verifications.Send(ctx, user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
 
 	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
 		Status: "ok",
@@ -134,23 +183,44 @@ func (a AuthHandler) SignIn(c fiber.Ctx) error {
 			Message: "can't make a fetch",
 		})
 	}
+	// The guard runs before the password is checked, unknown logins are refused like known ones.
+	attempt, ok, guardErr := guardSignIn(c, a.signInGuard, user.Id, request.Login)
+	if !ok {
+		return guardErr
+	}
 	if errors.Is(err, sql.ErrNoRows) {
 		slog.ErrorContext(ctx, err.Error())
+		signInFailed(c, a.signInGuard, attempt, db.User{})
 		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "incorrect login or password",
 		})
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password)); err != nil {
+	valid, err := a.passwordHasher.Verify(user.Password, request.Password)
+	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
+	}
+	if !valid {
+		signInFailed(c, a.signInGuard, attempt, user)
 		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
 			Code:    http.StatusBadRequest,
 			Message: "incorrect login or password",
 		})
 	}
+	signInSucceeded(c, a.signInGuard, attempt)
+	if a.passwordHasher.NeedsRehash(user.Password) {
+		a.rehashPassword(ctx, user.Id, request.Password)
+	}
 
-	tokens, err := a.authorizer.CreateTokens(user.Login)
+	tokens, err := a.authorizer.CreateTokens(ctx, user.Id, jwt.Authentication{
+		Scope:     a.authorizer.GrantScopes(request.Scope),
+		Nonce:     request.Nonce,
+		AuthTime:  time.Now(),
+		Methods:   []string{jwt.AuthMethodPassword},
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IpAddress: c.IP(),
+	})
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -159,25 +229,84 @@ func (a AuthHandler) SignIn(c fiber.Ctx) error {
 		})
 	}
 
-	return c.Status(http.StatusOK).JSON(responses.TokensResponse{
-		AccessToken:  tokens.AccessToken,
-		RefreshToken: tokens.RefreshToken,
-	})
+	return tokensResponse(c, a.cookies, tokens)
 }
 
 func (a AuthHandler) Verify(c fiber.Ctx) error {
 	ctx := c.Context()
 
 	var request requests.VerifyAndRefreshRequest
-	if err := json.Unmarshal(c.Body(), &request); err != nil {
+	if len(c.Body()) > 0 || !a.cookies.Enabled() {
+		if err := json.Unmarshal(c.Body(), &request); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "request body not parsed",
+			})
+		}
+	}
+	refreshToken, ok, err := a.refreshToken(c, request.RefreshToken)
+	if !ok {
+		return err
+	}
+
+	tokens, err := a.authorizer.ValidateAndUpdate(ctx, refreshToken, "")
+	if errors.Is(err, jwt.ErrRefreshTokenReused) {
+		slog.WarnContext(ctx, "refresh token reuse detected, token family revoked")
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+	return tokensResponse(c, a.cookies, tokens)
+}
+
+func (a AuthHandler) Logout(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.LogoutRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &request); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "request body not parsed",
+			})
+		}
+	}
+
+	refreshToken, ok, err := a.refreshToken(c, request.RefreshToken)
+	if !ok {
+		return err
+	}
+
+	if err := a.authorizer.Logout(ctx, middlewares.TokenClaims(c), refreshToken); err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
 			Code:    http.StatusBadRequest,
-			Message: "request body not parsed",
+			Message: "logout failed",
 		})
 	}
+	if a.cookies.Enabled() {
+		a.cookies.Clear(c)
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+func (a AuthHandler) LogoutEverywhere(c fiber.Ctx) error {
+	ctx := c.Context()
 
-	tokens, err := a.authorizer.ValidateAndUpdate(request.RefreshToken)
+	userId, err := middlewares.TokenClaims(c).UserId()
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
@@ -185,15 +314,27 @@ func (a AuthHandler) Verify(c fiber.Ctx) error {
 			Message: "unauthorized",
 		})
 	}
-	return c.Status(http.StatusOK).JSON(responses.TokensResponse{
-		AccessToken: tokens.AccessToken,
+
+	if err := a.authorizer.LogoutEverywhere(ctx, userId); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if a.cookies.Enabled() {
+		a.cookies.Clear(c)
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
 	})
 }
 
 func (a AuthHandler) GoogleSignIn(c fiber.Ctx) error {
 	ctx := c.Context()
 
-	tokens, err := a.authorizer.CreateTokens(uuid.NewString())
+	state, err := a.authorizer.CreateStateToken(a.authorizer.GrantScopes(c.Query("scope")), c.Query("nonce"))
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -202,7 +343,7 @@ func (a AuthHandler) GoogleSignIn(c fiber.Ctx) error {
 		})
 	}
 
-	url := a.googleAuthorizer.AuthCodeURL(tokens.AccessToken)
+	url := a.googleAuthorizer.AuthCodeURL(state)
 
 	return c.Status(http.StatusOK).JSON(responses.Oauth2Response{
 		Url: url,
@@ -212,11 +353,9 @@ func (a AuthHandler) GoogleSignIn(c fiber.Ctx) error {
 func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 	ctx := c.Context()
 
-	state := c.FormValue("state")
-	ok, _, err := a.authorizer.Validate(state)
-
-	if err != nil || !ok {
-		slog.ErrorContext(ctx, "invalid oauth state: %w", err)
+	state, err := a.authorizer.ValidateState(c.FormValue("state"))
+	if err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("invalid oauth state: %v", err))
 		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
 			Code:    http.StatusUnauthorized,
 			Message: "unauthorized",
@@ -226,7 +365,7 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 	code := c.FormValue("code")
 	token, err := a.googleAuthorizer.Exchange(ctx, code)
 	if err != nil {
-		slog.ErrorContext(ctx, "exchange code", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("exchange code: %s", err.Error()))
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "failed to get the token",
@@ -236,7 +375,7 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 	client := a.googleAuthorizer.Client(context.Background(), token)
 	resp, err := client.Get("https://www.googleapis.com/oauth2/v2/userinfo")
 	if err != nil {
-		slog.ErrorContext(ctx, "get userinfo", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("get userinfo: %s", err.Error()))
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "failed to get google client",
@@ -246,14 +385,22 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 
 	var userInfo responses.GoogleUserInfo
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		slog.ErrorContext(ctx, "parse userinfo", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("parse userinfo: %s", err.Error()))
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
 			Code:    http.StatusInternalServerError,
 			Message: "failed to extract the user",
 		})
 	}
 
-	_, err = a.userGetter.GetByLogin(ctx, userInfo.Email)
+	// An unverified Google email doesn't prove owning the account with that email.
+	if !userInfo.VerifiedEmail {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "google email is not verified",
+		})
+	}
+
+	user, err := a.userGetter.GetByEmail(ctx, userInfo.Email)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -261,8 +408,16 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 			Message: "can't make a fetch",
 		})
 	}
+	// Anyone could have signed up with the email and a password of their own, linking the Google sign in
+	// to the account before its owner verified the email would hand it to them.
+	if err == nil && !user.EmailVerifiedAt.Valid {
+		return c.Status(http.StatusConflict).JSON(responses.ErrorResponse{
+			Code:    http.StatusConflict,
+			Message: "an account with this email exists, verify the email before signing in with google",
+		})
+	}
 	if errors.Is(err, sql.ErrNoRows) {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), 8)
+		hashedPassword, err := a.passwordHasher.Hash(uuid.NewString())
 		if err != nil {
 			slog.ErrorContext(ctx, err.Error())
 			return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -271,11 +426,13 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 			})
 		}
 
-		if err := a.userInserter.Insert(ctx, db.User{
-			Login:    uuid.NewString(),
-			Email:    userInfo.Email,
-			Password: string(hashedPassword),
-		}); err != nil {
+		user.Id, err = a.userInserter.Insert(ctx, db.User{
+			Login:           uuid.NewString(),
+			Email:           userInfo.Email,
+			Password:        hashedPassword,
+			EmailVerifiedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		})
+		if err != nil {
 			slog.ErrorContext(ctx, err.Error())
 			return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
 				Code:    http.StatusInternalServerError,
@@ -284,7 +441,54 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 		}
 	}
 
-	tokens, err := a.authorizer.CreateTokens(userInfo.Email)
+	tokens, err := a.authorizer.CreateTokens(ctx, user.Id, jwt.Authentication{
+		Scope:     state.Scopes(),
+		Nonce:     state.Nonce,
+		AuthTime:  time.Now(),
+		Methods:   []string{jwt.AuthMethodFederated},
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IpAddress: c.IP(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+
+	if a.cookies.Enabled() {
+		if _, err := a.cookies.Set(c, tokens.AccessToken, tokens.RefreshToken); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+				Code:    http.StatusInternalServerError,
+				Message: "internal server error",
+			})
+		}
+		// The id token is left out, urls end up in the browser history and server logs.
+		// Clients read the user from the userinfo endpoint with the cookie instead.
+		return c.Status(http.StatusPermanentRedirect).Redirect().To(a.clientURL)
+	}
+
+	redirectURL := a.clientURL + "?refresh=" + tokens.RefreshToken + "&access=" + tokens.AccessToken
+	if tokens.IdToken != "" {
+		redirectURL += "&id_token=" + tokens.IdToken
+	}
+	return c.Status(http.StatusPermanentRedirect).Redirect().To(redirectURL)
+}
+
+// Csrf returns the CSRF token cookie mode clients echo in the X-CSRF-Token header,
+// clients on another origin call it on start as they can't read the cookie.
+func (a AuthHandler) Csrf(c fiber.Ctx) error {
+	ctx := c.Context()
+	if !a.cookies.Enabled() {
+		return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+			Code:    http.StatusNotFound,
+			Message: "cookie mode is off",
+		})
+	}
+
+	csrfToken, err := a.cookies.CsrfToken(c)
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -292,5 +496,59 @@ func (a AuthHandler) GoogleCallback(c fiber.Ctx) error {
 			Message: "internal server error",
 		})
 	}
-	return c.Status(http.StatusPermanentRedirect).Redirect().To(a.clientURL + "?refresh=" + tokens.RefreshToken + "&access=" + tokens.AccessToken)
+	return c.Status(http.StatusOK).JSON(responses.CsrfResponse{
+		CsrfToken: csrfToken,
+	})
+}
+
+// rehashPassword upgrades the stored hash to the current algorithm and parameters,
+// a failure is only logged as the old hash stays valid.
+func (a AuthHandler) rehashPassword(ctx context.Context, userId int64, password string) {
+	hashedPassword, err := a.passwordHasher.Hash(password)
+	if err == nil {
+		err = a.passwordUpdater.UpdatePassword(ctx, userId, hashedPassword)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("rehash password: %s", err.Error()))
+	}
+}
+
+// tokensResponse returns the tokens in the body or, in cookie mode, sets them in cookies.
+func tokensResponse(c fiber.Ctx, cookies middlewares.TokenCookies, tokens jwt.Tokens) error {
+	if !cookies.Enabled() {
+		return c.Status(http.StatusOK).JSON(responses.TokensResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+			IdToken:      tokens.IdToken,
+		})
+	}
+
+	csrfToken, err := cookies.Set(c, tokens.AccessToken, tokens.RefreshToken)
+	if err != nil {
+		slog.ErrorContext(c.Context(), err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(responses.CookieTokensResponse{
+		CsrfToken: csrfToken,
+		IdToken:   tokens.IdToken,
+	})
+}
+
+// refreshToken returns the refresh token of the body or, in cookie mode, of the cookie,
+// ok is false when the error response has been written already.
+func (a AuthHandler) refreshToken(c fiber.Ctx, bodyToken string) (string, bool, error) {
+	if bodyToken != "" {
+		return bodyToken, true, nil
+	}
+	token := a.cookies.RefreshToken(c)
+	if token != "" && !a.cookies.ValidCsrf(c) {
+		return "", false, c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "csrf token not valid",
+		})
+	}
+	return token, true, nil
 }
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/antlko/goauth-boilerplate/internal/verification"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type emailVerifier interface {
+	Send(ctx context.Context, user db.User, locale string) (time.Duration, error)
+	Verify(ctx context.Context, token string) (int64, error)
+}
+
+type EmailVerificationHandler struct {
+	emailVerifier  emailVerifier
+	userGetterById userGetterById
+}
+
+func NewEmailVerificationHandler(emailVerifier emailVerifier, userGetterById userGetterById) EmailVerificationHandler {
+	return EmailVerificationHandler{
+		emailVerifier:  emailVerifier,
+		userGetterById: userGetterById,
+	}
+}
+
+// Verify marks the email verified with the token of the verification link. Restricted tokens
+// get the granted scope back on the next refresh.
+func (h EmailVerificationHandler) Verify(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.VerifyEmailRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	userId, err := h.emailVerifier.Verify(ctx, request.Token)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "verification token not valid",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("user %d verified the email", userId))
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// Resend emails a new verification link to the signed in user, it is throttled per user.
+func (h EmailVerificationHandler) Resend(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	user, err := h.userGetterById.GetById(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	if user.EmailVerifiedAt.Valid {
+		return c.Status(http.StatusConflict).JSON(responses.ErrorResponse{
+			Code:    http.StatusConflict,
+			Message: "email already verified",
+		})
+	}
+
+	wait, err := h.emailVerifier.Send(ctx, user, c.Get(fiber.HeaderAcceptLanguage))
+	if errors.Is(err, verification.ErrThrottled) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.Status(http.StatusTooManyRequests).JSON(responses.ErrorResponse{
+			Code:    http.StatusTooManyRequests,
+			Message: "too many verification emails, try again later",
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "verification not sent",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
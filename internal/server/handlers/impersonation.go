@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RFC 8693 token exchange identifiers.
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	oauth2ErrInvalidTarget = "invalid_target"
+)
+
+type (
+	impersonator interface {
+		Impersonate(ctx context.Context, actor jwt.Claims, userId int64, scope []string) (jwt.Tokens, error)
+		GrantScopes(requested string) []string
+	}
+	auditLogger interface {
+		Insert(ctx context.Context, log db.AuditLog) error
+	}
+)
+
+// ImpersonationHandler lets admins act as another user, it is routed behind
+// RequirePermission(db.PermissionUsersImpersonate).
+type ImpersonationHandler struct {
+	impersonator impersonator
+	auditLogger  auditLogger
+}
+
+func NewImpersonationHandler(impersonator impersonator, auditLogger auditLogger) ImpersonationHandler {
+	return ImpersonationHandler{
+		impersonator: impersonator,
+		auditLogger:  auditLogger,
+	}
+}
+
+// Exchange is the RFC 8693 token exchange for impersonation: the admin authenticated by the bearer token
+// is the actor and requested_subject the id of the user to act as. Every issued token is audited.
+func (h ImpersonationHandler) Exchange(c fiber.Ctx) error {
+	ctx := c.Context()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	if c.FormValue("grant_type") != tokenExchangeGrantType {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrUnsupportedGrantType, "")
+	}
+	if tokenType := c.FormValue("requested_token_type"); tokenType != "" && tokenType != accessTokenType {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidRequest, "only access tokens can be requested")
+	}
+	userId, err := strconv.ParseInt(c.FormValue("requested_subject"), 10, 64)
+	if err != nil {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidRequest, "requested_subject must be a user id")
+	}
+
+	actor := middlewares.TokenClaims(c)
+	actorId, err := actor.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusForbidden, oauth2ErrAccessDenied, "")
+	}
+	scope := h.impersonator.GrantScopes(c.FormValue("scope"))
+	tokens, err := h.impersonator.Impersonate(ctx, actor, userId, scope)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		switch {
+		case errors.Is(err, jwt.ErrImpersonationNotAllowed):
+			return oauth2Error(c, http.StatusForbidden, oauth2ErrAccessDenied, "impersonation not allowed")
+		case errors.Is(err, sql.ErrNoRows):
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidTarget, "user not found")
+		}
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+
+	details, err := json.Marshal(map[string]any{
+		"scope":      strings.Join(tokens.Scope, " "),
+		"expires_in": int64(tokens.ExpiresIn.Seconds()),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	// No token is handed out without its audit record.
+	if err := h.auditLogger.Insert(ctx, db.AuditLog{
+		Action:    db.AuditActionImpersonate,
+		ActorId:   sql.NullInt64{Int64: actorId, Valid: true},
+		SubjectId: sql.NullInt64{Int64: userId, Valid: true},
+		IpAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Details:   details,
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("user %d impersonates user %d", actorId, userId))
+
+	return c.Status(http.StatusOK).JSON(responses.TokenExchangeResponse{
+		AccessToken:     tokens.AccessToken,
+		IssuedTokenType: accessTokenType,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(tokens.ExpiresIn.Seconds()),
+		Scope:           strings.Join(tokens.Scope, " "),
+	})
+}
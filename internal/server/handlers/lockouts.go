@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/lockout"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	signInGuard interface {
+		Begin(ctx context.Context, userId int64, login, ipAddress string) (lockout.Attempt, time.Duration, error)
+		Failed(ctx context.Context, attempt lockout.Attempt, user db.User, locale string) error
+		Succeeded(ctx context.Context, attempt lockout.Attempt) error
+	}
+	accountUnlocker interface {
+		Unlock(ctx context.Context, token string) (int64, error)
+	}
+	lockoutStore interface {
+		ListActive(ctx context.Context) ([]db.Lockout, error)
+		Reset(ctx context.Context, userId int64) error
+	}
+)
+
+// LockoutHandler unlocks accounts locked by failed sign ins, List and Reset are routed behind
+// RequirePermission(db.PermissionUsersUnlock).
+type LockoutHandler struct {
+	accountUnlocker accountUnlocker
+	lockoutStore    lockoutStore
+	auditLogger     auditLogger
+}
+
+func NewLockoutHandler(accountUnlocker accountUnlocker, lockoutStore lockoutStore, auditLogger auditLogger) LockoutHandler {
+	return LockoutHandler{
+		accountUnlocker: accountUnlocker,
+		lockoutStore:    lockoutStore,
+		auditLogger:     auditLogger,
+	}
+}
+
+// Unlock clears the lockout with the token of the link emailed when the account was locked.
+func (h LockoutHandler) Unlock(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.UnlockAccountRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	userId, err := h.accountUnlocker.Unlock(ctx, request.Token)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "unlock token not valid",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("user %d unlocked the account", userId))
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// List returns the users with failed sign ins in a row or locked out.
+func (h LockoutHandler) List(c fiber.Ctx) error {
+	ctx := c.Context()
+	lockouts, err := h.lockoutStore.ListActive(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	response := make([]responses.Lockout, 0, len(lockouts))
+	for _, lockout := range lockouts {
+		item := responses.Lockout{
+			UserId:         lockout.UserId,
+			Login:          lockout.Login,
+			Email:          lockout.Email,
+			FailedAttempts: lockout.FailedAttempts,
+			NextAttemptAt:  lockout.NextAttemptAt,
+		}
+		if lockout.LockedUntil.Valid {
+			item.LockedUntil = &lockout.LockedUntil.Time
+		}
+		response = append(response, item)
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Reset clears the failed sign ins and the lock of the user, it is audited.
+func (h LockoutHandler) Reset(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return badUserId(c)
+	}
+	actorId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	if err := h.lockoutStore.Reset(ctx, userId); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+				Code:    http.StatusNotFound,
+				Message: "user not locked out",
+			})
+		}
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "lockout not reset",
+		})
+	}
+	// The user is unlocked already, a lost audit record is only logged.
+	if err := h.auditLogger.Insert(ctx, db.AuditLog{
+		Action:    db.AuditActionLockoutReset,
+		ActorId:   sql.NullInt64{Int64: actorId, Valid: true},
+		SubjectId: sql.NullInt64{Int64: userId, Valid: true},
+		IpAddress: c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	slog.InfoContext(ctx, fmt.Sprintf("user %d reset the lockout of user %d", actorId, userId))
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// guardSignIn reserves the sign in of the user with login before the password is checked, userId is zero
+// for unknown logins. It answers 429 with Retry-After when the guard refuses the sign in,
+// the returned bool reports whether the sign in may go on.
+func guardSignIn(c fiber.Ctx, guard signInGuard, userId int64, login string) (lockout.Attempt, bool, error) {
+	ctx := c.Context()
+	attempt, wait, err := guard.Begin(ctx, userId, login, c.IP())
+	if err == nil {
+		return attempt, true, nil
+	}
+	if message, refused := signInRefusal(err); refused {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return lockout.Attempt{}, false, c.Status(http.StatusTooManyRequests).JSON(responses.ErrorResponse{
+			Code:    http.StatusTooManyRequests,
+			Message: message,
+		})
+	}
+	slog.ErrorContext(ctx, err.Error())
+	return lockout.Attempt{}, false, c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: "internal server error",
+	})
+}
+
+// signInRefusal returns the message for the user when err refuses the sign in.
+func signInRefusal(err error) (string, bool) {
+	switch {
+	case errors.Is(err, lockout.ErrLocked):
+		return "account locked after too many failed sign ins, unlock it with the emailed link or try again later", true
+	case errors.Is(err, lockout.ErrBackoff), errors.Is(err, lockout.ErrIpBlocked):
+		return "too many failed sign ins, try again later", true
+	}
+	return "", false
+}
+
+// signInFailed records the failed sign in, user is the zero value for unknown logins.
+// The sign in is refused already, a failure is only logged.
+func signInFailed(c fiber.Ctx, guard signInGuard, attempt lockout.Attempt, user db.User) {
+	ctx := c.Context()
+	if err := guard.Failed(ctx, attempt, user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
+
+// signInSucceeded clears the failed sign ins of the user, the sign in goes on when it fails.
+func signInSucceeded(c fiber.Ctx, guard signInGuard, attempt lockout.Attempt) {
+	ctx := c.Context()
+	if err := guard.Succeeded(ctx, attempt); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
@@ -0,0 +1,806 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/oauth2/device"
+	"github.com/antlko/goauth-boilerplate/internal/oauth2/pkce"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/antlko/goauth-boilerplate/internal/server/views"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	authorizationCodeDuration = 5 * time.Minute
+	deviceCodeDuration        = 10 * time.Minute
+	// deviceCodeInterval is the minimal polling interval, every slow_down adds deviceCodeSlowDown to it (RFC 8628 3.5).
+	deviceCodeInterval = 5 * time.Second
+	deviceCodeSlowDown = 5 * time.Second
+	// userCodeMaxFailures is the number of wrong user codes a user may enter per userCodeFailureWindow (RFC 8628 5.1).
+	userCodeMaxFailures   = 5
+	userCodeFailureWindow = 15 * time.Minute
+
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// RFC 6749 error codes.
+const (
+	oauth2ErrInvalidRequest          = "invalid_request"
+	oauth2ErrInvalidClient           = "invalid_client"
+	oauth2ErrInvalidGrant            = "invalid_grant"
+	oauth2ErrUnsupportedGrantType    = "unsupported_grant_type"
+	oauth2ErrUnsupportedResponseType = "unsupported_response_type"
+	oauth2ErrInvalidScope            = "invalid_scope"
+	oauth2ErrUnauthorizedClient      = "unauthorized_client"
+	oauth2ErrServerError             = "server_error"
+	oauth2ErrAccessDenied            = "access_denied"
+	// RFC 8628 device flow polling errors.
+	oauth2ErrAuthorizationPending = "authorization_pending"
+	oauth2ErrSlowDown             = "slow_down"
+	oauth2ErrExpiredToken         = "expired_token"
+)
+
+type (
+	clientGetter interface {
+		GetById(ctx context.Context, id string) (db.Client, error)
+	}
+	authorizationCodeStore interface {
+		Insert(ctx context.Context, code db.AuthorizationCode) error
+		Consume(ctx context.Context, codeHash string) (db.AuthorizationCode, error)
+	}
+	deviceCodeStore interface {
+		Insert(ctx context.Context, code db.DeviceCode) error
+		GetPendingByUserCode(ctx context.Context, userCode string) (db.DeviceCode, error)
+		Decide(ctx context.Context, userCode, status string, userId int64, authTime time.Time, amr []string) error
+		Poll(ctx context.Context, deviceCodeHash string) (db.DeviceCode, error)
+		SlowDown(ctx context.Context, deviceCodeHash string, seconds int64) error
+		Consume(ctx context.Context, deviceCodeHash string) (db.DeviceCode, error)
+	}
+	userCodeAttemptStore interface {
+		Record(ctx context.Context, userId int64, window time.Duration) (db.UserCodeAttempts, error)
+		Forgive(ctx context.Context, userId int64) error
+	}
+	tokenIssuer interface {
+		CreateTokens(ctx context.Context, userId int64, auth jwt.Authentication) (jwt.Tokens, error)
+		CreateClientToken(clientId string, scope []string, duration time.Duration) (jwt.Tokens, error)
+		ValidateAndUpdate(ctx context.Context, refresh, clientId string) (jwt.Tokens, error)
+		Introspect(ctx context.Context, token, hint string) (jwt.Claims, error)
+		Revoke(ctx context.Context, token, hint, clientId string) error
+		GrantAllowedScopes(requested string, allowed []string) []string
+	}
+)
+
+// authorizeRequest holds the parameters of the authorize endpoint, they are
+// read from the query string on GET and from the sign in form on POST.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+type OAuth2Handler struct {
+	clientGetter           clientGetter
+	authorizationCodeStore authorizationCodeStore
+	deviceCodeStore        deviceCodeStore
+	userCodeAttemptStore   userCodeAttemptStore
+	userGetter             userGetter
+	passwordHasher         passwordHasher
+	signInGuard            signInGuard
+	tokenIssuer            tokenIssuer
+	cookies                middlewares.TokenCookies
+	// deviceVerificationURL is the page where signed in users enter the user code of the device flow.
+	deviceVerificationURL string
+}
+
+func NewOAuth2Handler(
+	clientGetter clientGetter,
+	authorizationCodeStore authorizationCodeStore,
+	deviceCodeStore deviceCodeStore,
+	userCodeAttemptStore userCodeAttemptStore,
+	userGetter userGetter,
+	passwordHasher passwordHasher,
+	signInGuard signInGuard,
+	tokenIssuer tokenIssuer,
+	cookies middlewares.TokenCookies,
+	deviceVerificationURL string,
+) OAuth2Handler {
+	return OAuth2Handler{
+		clientGetter:           clientGetter,
+		authorizationCodeStore: authorizationCodeStore,
+		deviceCodeStore:        deviceCodeStore,
+		userCodeAttemptStore:   userCodeAttemptStore,
+		userGetter:             userGetter,
+		passwordHasher:         passwordHasher,
+		signInGuard:            signInGuard,
+		tokenIssuer:            tokenIssuer,
+		cookies:                cookies,
+		deviceVerificationURL:  deviceVerificationURL,
+	}
+}
+
+// Authorize renders the sign in form for a valid authorization request.
+func (h OAuth2Handler) Authorize(c fiber.Ctx) error {
+	request := readAuthorizeRequest(c)
+	client, ok, err := h.validateAuthorizeRequest(c, request)
+	if !ok {
+		return err
+	}
+	return h.renderAuthorizeForm(c, http.StatusOK, client, request, "")
+}
+
+// AuthorizeSubmit checks the CSRF token and the credentials from the sign in form
+// and redirects back to the client with a single-use authorization code.
+func (h OAuth2Handler) AuthorizeSubmit(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	request := readAuthorizeRequest(c)
+	client, ok, err := h.validateAuthorizeRequest(c, request)
+	if !ok {
+		return err
+	}
+	if !h.cookies.ValidCsrfForm(c) {
+		return h.renderAuthorizeForm(c, http.StatusForbidden, client, request, "the sign in form expired, submit it again")
+	}
+	scopes := h.tokenIssuer.GrantAllowedScopes(request.Scope, client.AllowedScopes)
+
+	login := c.FormValue("login")
+	user, err := h.userGetter.GetByLogin(ctx, login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, err.Error())
+		return redirectAuthorizeError(c, request, oauth2ErrServerError, "")
+	}
+	attempt, wait, guardErr := h.signInGuard.Begin(ctx, user.Id, login, c.IP())
+	if message, refused := signInRefusal(guardErr); refused {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return h.renderAuthorizeForm(c, http.StatusTooManyRequests, client, request, message)
+	}
+	if guardErr != nil {
+		slog.ErrorContext(ctx, guardErr.Error())
+		return redirectAuthorizeError(c, request, oauth2ErrServerError, "")
+	}
+	var valid bool
+	if err == nil {
+		valid, err = h.passwordHasher.Verify(user.Password, c.FormValue("password"))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	if !valid {
+		signInFailed(c, h.signInGuard, attempt, user)
+		return h.renderAuthorizeForm(c, http.StatusUnauthorized, client, request, "incorrect login or password")
+	}
+	signInSucceeded(c, h.signInGuard, attempt)
+
+	code, err := opaque.Generate()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return redirectAuthorizeError(c, request, oauth2ErrServerError, "")
+	}
+	if err := h.authorizationCodeStore.Insert(ctx, db.AuthorizationCode{
+		CodeHash:            opaque.Hash(code),
+		ClientId:            client.Id,
+		UserId:              user.Id,
+		RedirectURI:         request.RedirectURI,
+		Scope:               strings.Join(scopes, " "),
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		AuthTime:            time.Now(),
+		Amr:                 []string{jwt.AuthMethodPassword},
+		ExpiresAt:           time.Now().Add(authorizationCodeDuration),
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return redirectAuthorizeError(c, request, oauth2ErrServerError, "")
+	}
+
+	return redirectAuthorize(c, request, url.Values{"code": {code}})
+}
+
+// Token is the oauth2 token endpoint, it exchanges authorization codes, device codes and refresh
+// tokens and issues tokens to confidential clients through the client credentials grant.
+func (h OAuth2Handler) Token(c fiber.Ctx) error {
+	ctx := c.Context()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2InvalidClient(c)
+	}
+
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		return h.exchangeAuthorizationCode(c, client)
+	case deviceCodeGrantType:
+		return h.exchangeDeviceCode(c, client)
+	case "client_credentials":
+		if !client.IsConfidential() {
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrUnauthorizedClient, "public clients can't use client credentials")
+		}
+		var duration time.Duration
+		if client.AccessTokenSeconds.Valid {
+			duration = time.Duration(client.AccessTokenSeconds.Int64) * time.Second
+		}
+		tokens, err := h.tokenIssuer.CreateClientToken(client.Id, jwt.GrantClientScopes(c.FormValue("scope"), client.AllowedScopes), duration)
+		if err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+		}
+		return oauth2Tokens(c, tokens)
+	case "refresh_token":
+		tokens, err := h.tokenIssuer.ValidateAndUpdate(ctx, c.FormValue("refresh_token"), client.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "refresh token is not valid")
+		}
+		return oauth2Tokens(c, tokens)
+	default:
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrUnsupportedGrantType, "")
+	}
+}
+
+// DeviceAuthorization is the RFC 8628 device authorization endpoint, the device shows the
+// user code and polls the token endpoint with the device code until the user decides.
+func (h OAuth2Handler) DeviceAuthorization(c fiber.Ctx) error {
+	ctx := c.Context()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2InvalidClient(c)
+	}
+
+	deviceCode, err := opaque.Generate()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	userCode, err := device.NewUserCode()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	if err := h.deviceCodeStore.Insert(ctx, db.DeviceCode{
+		DeviceCodeHash:  opaque.Hash(deviceCode),
+		UserCode:        userCode,
+		ClientId:        client.Id,
+		Scope:           strings.Join(h.tokenIssuer.GrantAllowedScopes(c.FormValue("scope"), client.AllowedScopes), " "),
+		IntervalSeconds: int64(deviceCodeInterval.Seconds()),
+		ExpiresAt:       time.Now().Add(deviceCodeDuration),
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         h.deviceVerificationURL,
+		VerificationURIComplete: h.deviceVerificationURL + "?" + url.Values{"user_code": {userCode}}.Encode(),
+		ExpiresIn:               int64(deviceCodeDuration.Seconds()),
+		Interval:                int64(deviceCodeInterval.Seconds()),
+	})
+}
+
+// Device shows the signed in user which client asks for access with the user code.
+func (h OAuth2Handler) Device(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+	if ok, err := h.recordUserCodeAttempt(c, userId); !ok {
+		return err
+	}
+	code, err := h.deviceCodeStore.GetPendingByUserCode(ctx, device.NormalizeUserCode(c.Query("user_code")))
+	h.forgiveUserCodeAttempt(ctx, userId, err)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return deviceCodeError(c, err)
+	}
+	client, err := h.clientGetter.GetById(ctx, code.ClientId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(responses.DeviceResponse{
+		ClientName: client.Name,
+		Scope:      code.Scope,
+	})
+}
+
+// DeviceDecision approves or denies the device flow of the user code on behalf of the signed in user.
+func (h OAuth2Handler) DeviceDecision(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.DeviceDecisionRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	claims := middlewares.TokenClaims(c)
+	if claims.IsPersonal() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "personal access tokens can't approve devices",
+		})
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+
+	if ok, err := h.recordUserCodeAttempt(c, userId); !ok {
+		return err
+	}
+	status := db.DeviceCodeDenied
+	if request.Approve {
+		status = db.DeviceCodeApproved
+	}
+	err = h.deviceCodeStore.Decide(
+		ctx,
+		device.NormalizeUserCode(request.UserCode),
+		status,
+		userId,
+		claims.AuthTime.Time,
+		claims.Amr,
+	)
+	h.forgiveUserCodeAttempt(ctx, userId, err)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return deviceCodeError(c, err)
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: status,
+	})
+}
+
+// Introspect is the RFC 7662 introspection endpoint for confidential clients.
+func (h OAuth2Handler) Introspect(c fiber.Ctx) error {
+	ctx := c.Context()
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	client, err := h.authenticateClient(c)
+	if err == nil && !client.IsConfidential() {
+		err = fmt.Errorf("public client %q can't introspect tokens", client.Id)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2InvalidClient(c)
+	}
+
+	claims, err := h.tokenIssuer.Introspect(ctx, c.FormValue("token"), c.FormValue("token_type_hint"))
+	if err != nil {
+		slog.InfoContext(ctx, fmt.Sprintf("inactive token introspected: %s", err.Error()))
+		return c.Status(http.StatusOK).JSON(responses.IntrospectionResponse{Active: false})
+	}
+
+	response := responses.IntrospectionResponse{
+		Active:      true,
+		TokenType:   "access_token",
+		Scope:       claims.Scope,
+		ClientId:    claims.ClientId,
+		Subject:     claims.Subject,
+		SubjectType: string(claims.SubjectType),
+		IssuedAt:    claims.IssuedAt.Unix(),
+		NotBefore:   claims.NotBefore.Unix(),
+		Issuer:      claims.Issuer,
+		Audience:    claims.Audience,
+		JwtId:       claims.ID,
+	}
+	switch claims.TokenUse {
+	case jwt.TokenUseRefresh:
+		response.TokenType = "refresh_token"
+	case jwt.TokenUsePersonal:
+		response.TokenType = "personal_access_token"
+	}
+	if claims.IsImpersonated() {
+		response.Actor = &responses.Actor{Subject: claims.Actor.Subject}
+	}
+	// Personal access tokens may never expire.
+	if claims.ExpiresAt != nil {
+		response.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Revoke is the RFC 7009 revocation endpoint, unknown and already revoked tokens are reported as revoked.
+func (h OAuth2Handler) Revoke(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2InvalidClient(c)
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidRequest, "token is required")
+	}
+	err = h.tokenIssuer.Revoke(ctx, token, c.FormValue("token_type_hint"), client.Id)
+	if errors.Is(err, jwt.ErrTokenClientMismatch) {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrUnauthorizedClient, "token was issued to another client")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusServiceUnavailable, oauth2ErrServerError, "")
+	}
+	return c.SendStatus(http.StatusOK)
+}
+
+func (h OAuth2Handler) exchangeAuthorizationCode(c fiber.Ctx, client db.Client) error {
+	ctx := c.Context()
+
+	code, err := h.authorizationCodeStore.Consume(ctx, opaque.Hash(c.FormValue("code")))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "authorization code is not valid")
+		}
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	if code.ClientId != client.Id || code.RedirectURI != c.FormValue("redirect_uri") {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "authorization code is not valid")
+	}
+	if !pkce.Verify(c.FormValue("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod) {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "code verifier doesn't match")
+	}
+
+	tokens, err := h.tokenIssuer.CreateTokens(ctx, code.UserId, jwt.Authentication{
+		Scope:     strings.Fields(code.Scope),
+		Nonce:     code.Nonce,
+		AuthTime:  code.AuthTime,
+		Methods:   code.Amr,
+		ClientId:  client.Id,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IpAddress: c.IP(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	return oauth2Tokens(c, tokens)
+}
+
+// exchangeDeviceCode answers a poll of the device, tokens are issued once the user approved the device code.
+func (h OAuth2Handler) exchangeDeviceCode(c fiber.Ctx, client db.Client) error {
+	ctx := c.Context()
+	deviceCodeHash := opaque.Hash(c.FormValue("device_code"))
+
+	code, err := h.deviceCodeStore.Poll(ctx, deviceCodeHash)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "device code is not valid")
+		}
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	if code.ClientId != client.Id {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "device code is not valid")
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrExpiredToken, "")
+	}
+	interval := time.Duration(code.IntervalSeconds) * time.Second
+	if code.LastPolledAt.Valid && time.Since(code.LastPolledAt.Time) < interval {
+		if err := h.deviceCodeStore.SlowDown(ctx, deviceCodeHash, int64(deviceCodeSlowDown.Seconds())); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+		}
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrSlowDown, "")
+	}
+
+	switch code.Status {
+	case db.DeviceCodePending:
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrAuthorizationPending, "")
+	case db.DeviceCodeDenied:
+		return oauth2Error(c, http.StatusBadRequest, oauth2ErrAccessDenied, "")
+	}
+
+	code, err = h.deviceCodeStore.Consume(ctx, deviceCodeHash)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return oauth2Error(c, http.StatusBadRequest, oauth2ErrInvalidGrant, "device code is not valid")
+		}
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+
+	tokens, err := h.tokenIssuer.CreateTokens(ctx, code.UserId.Int64, jwt.Authentication{
+		Scope:     strings.Fields(code.Scope),
+		AuthTime:  code.AuthTime.Time,
+		Methods:   code.Amr,
+		ClientId:  client.Id,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IpAddress: c.IP(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return oauth2Error(c, http.StatusInternalServerError, oauth2ErrServerError, "")
+	}
+	return oauth2Tokens(c, tokens)
+}
+
+// authenticateClient identifies the client by client_secret_basic, client_secret_post or,
+// for public clients, by the client_id parameter alone.
+func (h OAuth2Handler) authenticateClient(c fiber.Ctx) (db.Client, error) {
+	clientId, secret, ok := basicCredentials(c)
+	if !ok {
+		clientId, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+
+	client, err := h.clientGetter.GetById(c.Context(), clientId)
+	if err != nil {
+		return db.Client{}, fmt.Errorf("get client %q: %w", clientId, err)
+	}
+	if !client.IsConfidential() {
+		if secret != "" {
+			return db.Client{}, fmt.Errorf("secret sent for public client %q", clientId)
+		}
+		return client, nil
+	}
+	valid, err := h.passwordHasher.Verify(client.SecretHash.String, secret)
+	if err != nil {
+		return db.Client{}, fmt.Errorf("client %q secret: %w", clientId, err)
+	}
+	if !valid {
+		return db.Client{}, fmt.Errorf("client %q secret mismatch", clientId)
+	}
+	return client, nil
+}
+
+// basicCredentials parses the Authorization header, client id and secret are form-urlencoded (RFC 6749 2.3.1).
+func basicCredentials(c fiber.Ctx) (string, string, bool) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Basic ") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Basic "))
+	if err != nil {
+		return "", "", false
+	}
+	encodedId, encodedSecret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	clientId, err := url.QueryUnescape(encodedId)
+	if err != nil {
+		return "", "", false
+	}
+	secret, err := url.QueryUnescape(encodedSecret)
+	if err != nil {
+		return "", "", false
+	}
+	return clientId, secret, true
+}
+
+// validateAuthorizeRequest responds itself when the request is not valid. Errors with
+// the client or redirect uri are shown to the user, others are redirected to the client.
+func (h OAuth2Handler) validateAuthorizeRequest(c fiber.Ctx, request authorizeRequest) (db.Client, bool, error) {
+	ctx := c.Context()
+
+	client, err := h.clientGetter.GetById(ctx, request.ClientId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return db.Client{}, false, renderAuthorize(c, http.StatusBadRequest, views.AuthorizeData{
+			Error: "unknown client",
+		})
+	}
+	// Redirect uris are compared exactly, without any normalisation or prefix matching.
+	if request.RedirectURI == "" || !slices.Contains(client.RedirectURIs, request.RedirectURI) {
+		return db.Client{}, false, renderAuthorize(c, http.StatusBadRequest, views.AuthorizeData{
+			Error: "redirect uri is not registered for the client",
+		})
+	}
+
+	if request.ResponseType != "code" {
+		return db.Client{}, false, redirectAuthorizeError(c, request, oauth2ErrUnsupportedResponseType, "")
+	}
+	if !pkce.ValidChallenge(request.CodeChallenge, request.CodeChallengeMethod) {
+		return db.Client{}, false, redirectAuthorizeError(c, request, oauth2ErrInvalidRequest, "code_challenge with S256 method is required")
+	}
+	granted := h.tokenIssuer.GrantAllowedScopes(request.Scope, client.AllowedScopes)
+	for _, scope := range strings.Fields(request.Scope) {
+		if !slices.Contains(granted, scope) {
+			return db.Client{}, false, redirectAuthorizeError(c, request, oauth2ErrInvalidScope, "scope "+scope+" is not allowed")
+		}
+	}
+	return client, true, nil
+}
+
+func readAuthorizeRequest(c fiber.Ctx) authorizeRequest {
+	value := c.Query
+	if c.Method() == http.MethodPost {
+		value = c.FormValue
+	}
+	return authorizeRequest{
+		ResponseType:        value("response_type"),
+		ClientId:            value("client_id"),
+		RedirectURI:         value("redirect_uri"),
+		Scope:               value("scope"),
+		State:               value("state"),
+		Nonce:               value("nonce"),
+		CodeChallenge:       value("code_challenge"),
+		CodeChallengeMethod: value("code_challenge_method"),
+	}
+}
+
+func (r authorizeRequest) params() map[string]string {
+	return map[string]string{
+		"response_type":         r.ResponseType,
+		"client_id":             r.ClientId,
+		"redirect_uri":          r.RedirectURI,
+		"scope":                 r.Scope,
+		"state":                 r.State,
+		"nonce":                 r.Nonce,
+		"code_challenge":        r.CodeChallenge,
+		"code_challenge_method": r.CodeChallengeMethod,
+	}
+}
+
+// renderAuthorizeForm renders the sign in form with an optional error message, the CSRF
+// cookie is issued when the browser has none yet.
+func (h OAuth2Handler) renderAuthorizeForm(c fiber.Ctx, status int, client db.Client, request authorizeRequest, message string) error {
+	csrfToken, err := h.cookies.CsrfToken(c)
+	if err != nil {
+		slog.ErrorContext(c.Context(), err.Error())
+		return redirectAuthorizeError(c, request, oauth2ErrServerError, "")
+	}
+	return renderAuthorize(c, status, views.AuthorizeData{
+		ClientName: client.Name,
+		Scopes:     h.tokenIssuer.GrantAllowedScopes(request.Scope, client.AllowedScopes),
+		Error:      message,
+		Params:     request.params(),
+		CsrfToken:  csrfToken,
+		ShowForm:   true,
+	})
+}
+
+func renderAuthorize(c fiber.Ctx, status int, data views.AuthorizeData) error {
+	var page bytes.Buffer
+	if err := views.Authorize.Execute(&page, data); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderXFrameOptions, "DENY")
+	return c.Status(status).Type("html").Send(page.Bytes())
+}
+
+// redirectAuthorize sends the user back to the validated redirect uri with the state attached.
+func redirectAuthorize(c fiber.Ctx, request authorizeRequest, params url.Values) error {
+	redirectURI, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		return err
+	}
+	query := redirectURI.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+	return c.Redirect().Status(http.StatusFound).To(redirectURI.String())
+}
+
+func redirectAuthorizeError(c fiber.Ctx, request authorizeRequest, code, description string) error {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	return redirectAuthorize(c, request, params)
+}
+
+// recordUserCodeAttempt counts a user code entry of the user as failed before the code is looked up,
+// it responds itself with 429 once the user entered userCodeMaxFailures wrong codes in the window.
+func (h OAuth2Handler) recordUserCodeAttempt(c fiber.Ctx, userId int64) (bool, error) {
+	ctx := c.Context()
+
+	attempts, err := h.userCodeAttemptStore.Record(ctx, userId, userCodeFailureWindow)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return false, c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	if attempts.FailedAttempts > userCodeMaxFailures {
+		wait := time.Until(attempts.WindowStartedAt.Add(userCodeFailureWindow))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return false, c.Status(http.StatusTooManyRequests).JSON(responses.ErrorResponse{
+			Code:    http.StatusTooManyRequests,
+			Message: "too many wrong user codes, try again later",
+		})
+	}
+	return true, nil
+}
+
+// forgiveUserCodeAttempt takes back the recorded attempt unless the user code was not found.
+func (h OAuth2Handler) forgiveUserCodeAttempt(ctx context.Context, userId int64, lookupErr error) {
+	if errors.Is(lookupErr, sql.ErrNoRows) {
+		return
+	}
+	if err := h.userCodeAttemptStore.Forgive(ctx, userId); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+}
+
+func deviceCodeError(c fiber.Ctx, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+			Code:    http.StatusNotFound,
+			Message: "user code is not valid",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: "can't make a fetch",
+	})
+}
+
+func oauth2Tokens(c fiber.Ctx, tokens jwt.Tokens) error {
+	return c.Status(http.StatusOK).JSON(responses.OAuth2TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		IdToken:      tokens.IdToken,
+		Scope:        strings.Join(tokens.Scope, " "),
+	})
+}
+
+func oauth2InvalidClient(c fiber.Ctx) error {
+	if strings.HasPrefix(c.Get(fiber.HeaderAuthorization), "Basic ") {
+		c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="oauth2"`)
+	}
+	return oauth2Error(c, http.StatusUnauthorized, oauth2ErrInvalidClient, "client authentication failed")
+}
+
+func oauth2Error(c fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(responses.OAuth2ErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,867 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/lockout"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	gojwt "github.com/golang-jwt/jwt/v4"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The RFC 7636 appendix B example.
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	testClientId    = "spa"
+	testRedirectURI = "https://app.example.com/callback"
+	testLogin       = "alice"
+	testPassword    = "correct horse battery staple"
+)
+
+type fakeClients map[string]db.Client
+
+func (f fakeClients) GetById(_ context.Context, id string) (db.Client, error) {
+	client, ok := f[id]
+	if !ok {
+		return db.Client{}, sql.ErrNoRows
+	}
+	return client, nil
+}
+
+type fakeAuthorizationCodes struct {
+	mu    sync.Mutex
+	codes map[string]db.AuthorizationCode
+}
+
+// Insert copies the strings, fiber reuses the request buffers they point to once the handler returns.
+func (f *fakeAuthorizationCodes) Insert(_ context.Context, code db.AuthorizationCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code.RedirectURI = strings.Clone(code.RedirectURI)
+	code.Scope = strings.Clone(code.Scope)
+	code.Nonce = strings.Clone(code.Nonce)
+	code.CodeChallenge = strings.Clone(code.CodeChallenge)
+	code.CodeChallengeMethod = strings.Clone(code.CodeChallengeMethod)
+	f.codes[code.CodeHash] = code
+	return nil
+}
+
+// Consume mirrors db.AuthorizationCodeRepo, codes are used once and only before they expire.
+func (f *fakeAuthorizationCodes) Consume(_ context.Context, codeHash string) (db.AuthorizationCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.codes[codeHash]
+	if !ok || code.UsedAt.Valid || !code.ExpiresAt.After(time.Now()) {
+		return db.AuthorizationCode{}, sql.ErrNoRows
+	}
+	code.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.codes[codeHash] = code
+	return code, nil
+}
+
+type fakeDeviceCodes struct {
+	mu    sync.Mutex
+	codes map[string]db.DeviceCode
+}
+
+func (f *fakeDeviceCodes) Insert(_ context.Context, code db.DeviceCode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code.Status = db.DeviceCodePending
+	f.codes[code.DeviceCodeHash] = code
+	return nil
+}
+
+func (f *fakeDeviceCodes) GetPendingByUserCode(_ context.Context, userCode string) (db.DeviceCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, code := range f.codes {
+		if code.UserCode == userCode && code.Status == db.DeviceCodePending && code.ExpiresAt.After(time.Now()) {
+			return code, nil
+		}
+	}
+	return db.DeviceCode{}, sql.ErrNoRows
+}
+
+func (f *fakeDeviceCodes) Decide(_ context.Context, userCode, status string, userId int64, authTime time.Time, amr []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for hash, code := range f.codes {
+		if code.UserCode == userCode && code.Status == db.DeviceCodePending && code.ExpiresAt.After(time.Now()) {
+			code.Status = status
+			code.UserId = sql.NullInt64{Int64: userId, Valid: true}
+			code.AuthTime = sql.NullTime{Time: authTime, Valid: true}
+			code.Amr = amr
+			f.codes[hash] = code
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+// Poll mirrors db.DeviceCodeRepo, the code is returned as it was before the poll.
+func (f *fakeDeviceCodes) Poll(_ context.Context, deviceCodeHash string) (db.DeviceCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.codes[deviceCodeHash]
+	if !ok || code.UsedAt.Valid {
+		return db.DeviceCode{}, sql.ErrNoRows
+	}
+	polled := code
+	polled.LastPolledAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.codes[deviceCodeHash] = polled
+	return code, nil
+}
+
+func (f *fakeDeviceCodes) SlowDown(_ context.Context, deviceCodeHash string, seconds int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := f.codes[deviceCodeHash]
+	code.IntervalSeconds += seconds
+	f.codes[deviceCodeHash] = code
+	return nil
+}
+
+func (f *fakeDeviceCodes) Consume(_ context.Context, deviceCodeHash string) (db.DeviceCode, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code, ok := f.codes[deviceCodeHash]
+	if !ok || code.Status != db.DeviceCodeApproved || code.UsedAt.Valid || !code.ExpiresAt.After(time.Now()) {
+		return db.DeviceCode{}, sql.ErrNoRows
+	}
+	code.UsedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	f.codes[deviceCodeHash] = code
+	return code, nil
+}
+
+// update changes the stored code behind the device code, the tests use it to move time forward.
+func (f *fakeDeviceCodes) update(deviceCode string, change func(code *db.DeviceCode)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	code := f.codes[opaque.Hash(deviceCode)]
+	change(&code)
+	f.codes[opaque.Hash(deviceCode)] = code
+}
+
+// fakeUserCodeAttempts mirrors db.UserCodeAttemptRepo.
+type fakeUserCodeAttempts struct {
+	mu       sync.Mutex
+	attempts map[int64]db.UserCodeAttempts
+}
+
+func (f *fakeUserCodeAttempts) Record(_ context.Context, userId int64, window time.Duration) (db.UserCodeAttempts, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attempts, ok := f.attempts[userId]
+	if !ok || !attempts.WindowStartedAt.After(time.Now().Add(-window)) {
+		attempts = db.UserCodeAttempts{WindowStartedAt: time.Now()}
+	}
+	attempts.FailedAttempts++
+	f.attempts[userId] = attempts
+	return attempts, nil
+}
+
+func (f *fakeUserCodeAttempts) Forgive(_ context.Context, userId int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	attempts := f.attempts[userId]
+	attempts.FailedAttempts = max(attempts.FailedAttempts-1, 0)
+	f.attempts[userId] = attempts
+	return nil
+}
+
+// fakeTokenValidator accepts any access token, the user id follows the "access-" prefix.
+type fakeTokenValidator struct{}
+
+func (fakeTokenValidator) ValidateAccess(_ context.Context, token string) (jwt.Claims, error) {
+	token = strings.TrimPrefix(token, "access-")
+	return jwt.Claims{
+		TokenUse:         jwt.TokenUseAccess,
+		AuthTime:         gojwt.NewNumericDate(time.Now()),
+		Amr:              []string{jwt.AuthMethodPassword},
+		RegisteredClaims: gojwt.RegisteredClaims{Subject: token},
+	}, nil
+}
+
+type fakeUsers []db.User
+
+func (f fakeUsers) GetByLoginOrEmail(ctx context.Context, login, email string) (db.User, error) {
+	user, err := f.GetByLogin(ctx, login)
+	if err != nil {
+		return f.GetByEmail(ctx, email)
+	}
+	return user, nil
+}
+
+func (f fakeUsers) GetByLogin(_ context.Context, login string) (db.User, error) {
+	for _, user := range f {
+		if user.Login == login {
+			return user, nil
+		}
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+func (f fakeUsers) GetByEmail(_ context.Context, email string) (db.User, error) {
+	for _, user := range f {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+// fakeHasher "hashes" by prefixing the password.
+type fakeHasher struct{}
+
+func (fakeHasher) Hash(password string) (string, error) {
+	return "hash:" + password, nil
+}
+
+func (fakeHasher) Verify(encoded, password string) (bool, error) {
+	return encoded == "hash:"+password, nil
+}
+
+func (fakeHasher) NeedsRehash(string) bool {
+	return false
+}
+
+type fakeGuard struct{}
+
+func (fakeGuard) Begin(context.Context, int64, string, string) (lockout.Attempt, time.Duration, error) {
+	return lockout.Attempt{}, 0, nil
+}
+
+func (fakeGuard) Failed(context.Context, lockout.Attempt, db.User, string) error {
+	return nil
+}
+
+func (fakeGuard) Succeeded(context.Context, lockout.Attempt) error {
+	return nil
+}
+
+type fakeTokenIssuer struct {
+	scopes []string
+}
+
+func (f fakeTokenIssuer) CreateTokens(_ context.Context, userId int64, auth jwt.Authentication) (jwt.Tokens, error) {
+	return jwt.Tokens{AccessToken: "access", Scope: auth.Scope, ExpiresIn: time.Minute}, nil
+}
+
+func (f fakeTokenIssuer) CreateClientToken(clientId string, scope []string, duration time.Duration) (jwt.Tokens, error) {
+	return jwt.Tokens{AccessToken: "client", Scope: scope, ExpiresIn: duration}, nil
+}
+
+func (f fakeTokenIssuer) ValidateAndUpdate(context.Context, string, string) (jwt.Tokens, error) {
+	return jwt.Tokens{}, jwt.ErrTokenClientMismatch
+}
+
+func (f fakeTokenIssuer) Introspect(context.Context, string, string) (jwt.Claims, error) {
+	return jwt.Claims{}, jwt.ErrTokenClientMismatch
+}
+
+func (f fakeTokenIssuer) Revoke(context.Context, string, string, string) error {
+	return nil
+}
+
+func (f fakeTokenIssuer) GrantAllowedScopes(requested string, allowed []string) []string {
+	var granted []string
+	for _, scope := range strings.Fields(requested) {
+		if slices.Contains(f.scopes, scope) && slices.Contains(allowed, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+type oauth2Test struct {
+	app      *fiber.App
+	codes    *fakeAuthorizationCodes
+	devices  *fakeDeviceCodes
+	attempts *fakeUserCodeAttempts
+}
+
+func newOAuth2Test(t *testing.T) oauth2Test {
+	t.Helper()
+	cookies, err := middlewares.NewTokenCookies(middlewares.CookieConfig{CookieSameSite: "lax"}, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := &fakeAuthorizationCodes{codes: map[string]db.AuthorizationCode{}}
+	devices := &fakeDeviceCodes{codes: map[string]db.DeviceCode{}}
+	attempts := &fakeUserCodeAttempts{attempts: map[int64]db.UserCodeAttempts{}}
+	h := NewOAuth2Handler(
+		fakeClients{
+			testClientId: {Id: testClientId, Name: "SPA", RedirectURIs: []string{testRedirectURI}, AllowedScopes: []string{"openid", "email"}},
+			"other":      {Id: "other", Name: "Other", RedirectURIs: []string{testRedirectURI}, AllowedScopes: []string{"openid"}},
+		},
+		codes,
+		devices,
+		attempts,
+		fakeUsers{{Id: 1, Login: testLogin, Email: "alice@example.com", Password: "hash:" + testPassword}},
+		fakeHasher{},
+		fakeGuard{},
+		fakeTokenIssuer{scopes: []string{"openid", "email", "profile"}},
+		cookies,
+		"https://example.com/device",
+	)
+
+	app := fiber.New()
+	app.Get("/authorize", h.Authorize)
+	app.Post("/authorize", h.AuthorizeSubmit)
+	app.Post("/token", h.Token)
+	app.Post("/device/code", h.DeviceAuthorization)
+	protected := app.Group("/protected", middlewares.BearerVerifier(fakeTokenValidator{}, cookies))
+	protected.Get("/device", h.Device)
+	protected.Post("/device", h.DeviceDecision)
+	return oauth2Test{app: app, codes: codes, devices: devices, attempts: attempts}
+}
+
+func (o oauth2Test) do(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := o.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func authorizeParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientId},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {testCodeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// openForm renders the sign in form and returns the CSRF cookie and the token of the hidden field.
+func (o oauth2Test) openForm(t *testing.T, params url.Values) (*http.Cookie, string) {
+	t.Helper()
+	resp := o.do(t, httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("authorize status %d", resp.StatusCode)
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == middlewares.CsrfTokenCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no csrf cookie")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match := csrfFieldPattern.FindSubmatch(body)
+	if match == nil {
+		t.Fatal("no csrf field in the form")
+	}
+	return cookie, string(match[1])
+}
+
+func (o oauth2Test) submit(t *testing.T, form url.Values, cookie *http.Cookie) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return o.do(t, req)
+}
+
+// authorize signs in through the form and returns the authorization code.
+func (o oauth2Test) authorize(t *testing.T) string {
+	t.Helper()
+	cookie, csrfToken := o.openForm(t, authorizeParams())
+	form := authorizeParams()
+	form.Set("csrf_token", csrfToken)
+	form.Set("login", testLogin)
+	form.Set("password", testPassword)
+	resp := o.submit(t, form, cookie)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("submit status %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in %s", location)
+	}
+	return code
+}
+
+func (o oauth2Test) exchange(t *testing.T, form url.Values) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp := o.do(t, req)
+	var body responses.OAuth2ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body.Error
+}
+
+func exchangeForm(code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {testClientId},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testCodeVerifier},
+	}
+}
+
+func TestAuthorizeRedirectURIExactMatch(t *testing.T) {
+	o := newOAuth2Test(t)
+	tests := []struct {
+		name        string
+		redirectURI string
+		wantStatus  int
+	}{
+		{name: "registered", redirectURI: testRedirectURI, wantStatus: http.StatusOK},
+		{name: "empty", redirectURI: "", wantStatus: http.StatusBadRequest},
+		{name: "trailing slash", redirectURI: testRedirectURI + "/", wantStatus: http.StatusBadRequest},
+		{name: "sub path", redirectURI: testRedirectURI + "/evil", wantStatus: http.StatusBadRequest},
+		{name: "extra query", redirectURI: testRedirectURI + "?next=evil", wantStatus: http.StatusBadRequest},
+		{name: "uppercase host", redirectURI: "https://APP.example.com/callback", wantStatus: http.StatusBadRequest},
+		{name: "http scheme", redirectURI: "http://app.example.com/callback", wantStatus: http.StatusBadRequest},
+		{name: "explicit port", redirectURI: "https://app.example.com:443/callback", wantStatus: http.StatusBadRequest},
+		{name: "other host", redirectURI: "https://app.example.com.evil.com/callback", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := authorizeParams()
+			params.Set("redirect_uri", tt.redirectURI)
+			resp := o.do(t, httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil))
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			// Unregistered redirect uris are never redirected to.
+			if location := resp.Header.Get(fiber.HeaderLocation); location != "" {
+				t.Errorf("redirected to %s", location)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequiresPKCE(t *testing.T) {
+	o := newOAuth2Test(t)
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+	}{
+		{name: "no challenge", method: "S256"},
+		{name: "plain method", challenge: testCodeChallenge, method: "plain"},
+		{name: "no method", challenge: testCodeChallenge},
+		{name: "malformed challenge", challenge: "challenge", method: "S256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := authorizeParams()
+			params.Set("code_challenge", tt.challenge)
+			params.Set("code_challenge_method", tt.method)
+			resp := o.do(t, httptest.NewRequest(http.MethodGet, "/authorize?"+params.Encode(), nil))
+			if resp.StatusCode != http.StatusFound {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusFound)
+			}
+			location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := location.Query().Get("error"); got != oauth2ErrInvalidRequest {
+				t.Errorf("error %q, want %q", got, oauth2ErrInvalidRequest)
+			}
+			if got := location.Query().Get("state"); got != "xyz" {
+				t.Errorf("state %q", got)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSubmitCsrf(t *testing.T) {
+	o := newOAuth2Test(t)
+	cookie, csrfToken := o.openForm(t, authorizeParams())
+
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		field      string
+		wantStatus int
+	}{
+		{name: "matching token", cookie: cookie, field: csrfToken, wantStatus: http.StatusFound},
+		{name: "no cookie", field: csrfToken, wantStatus: http.StatusForbidden},
+		{name: "no field", cookie: cookie, wantStatus: http.StatusForbidden},
+		{name: "other token", cookie: cookie, field: csrfToken + "x", wantStatus: http.StatusForbidden},
+		{name: "empty cookie and field", cookie: &http.Cookie{Name: middlewares.CsrfTokenCookie}, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := authorizeParams()
+			form.Set("login", testLogin)
+			form.Set("password", testPassword)
+			if tt.field != "" {
+				form.Set("csrf_token", tt.field)
+			}
+			resp := o.submit(t, form, tt.cookie)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSubmitWrongPassword(t *testing.T) {
+	o := newOAuth2Test(t)
+	cookie, csrfToken := o.openForm(t, authorizeParams())
+	form := authorizeParams()
+	form.Set("csrf_token", csrfToken)
+	form.Set("login", testLogin)
+	form.Set("password", "wrong")
+	resp := o.submit(t, form, cookie)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if len(o.codes.codes) != 0 {
+		t.Error("code issued for a wrong password")
+	}
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(form url.Values)
+		wantError string
+	}{
+		{name: "valid", change: func(url.Values) {}},
+		{name: "wrong verifier", change: func(form url.Values) { form.Set("code_verifier", strings.Repeat("a", 43)) }, wantError: oauth2ErrInvalidGrant},
+		{name: "no verifier", change: func(form url.Values) { form.Del("code_verifier") }, wantError: oauth2ErrInvalidGrant},
+		{name: "challenge as verifier", change: func(form url.Values) { form.Set("code_verifier", testCodeChallenge) }, wantError: oauth2ErrInvalidGrant},
+		{name: "other redirect uri", change: func(form url.Values) { form.Set("redirect_uri", testRedirectURI+"/") }, wantError: oauth2ErrInvalidGrant},
+		{name: "no redirect uri", change: func(form url.Values) { form.Del("redirect_uri") }, wantError: oauth2ErrInvalidGrant},
+		{name: "other client", change: func(form url.Values) { form.Set("client_id", "other") }, wantError: oauth2ErrInvalidGrant},
+		{name: "unknown code", change: func(form url.Values) { form.Set("code", "unknown") }, wantError: oauth2ErrInvalidGrant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOAuth2Test(t)
+			form := exchangeForm(o.authorize(t))
+			tt.change(form)
+			status, errorCode := o.exchange(t, form)
+			if errorCode != tt.wantError {
+				t.Errorf("error %q, want %q", errorCode, tt.wantError)
+			}
+			wantStatus := http.StatusOK
+			if tt.wantError != "" {
+				wantStatus = http.StatusBadRequest
+			}
+			if status != wantStatus {
+				t.Errorf("status %d, want %d", status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeSingleUse(t *testing.T) {
+	o := newOAuth2Test(t)
+	code := o.authorize(t)
+	if status, errorCode := o.exchange(t, exchangeForm(code)); status != http.StatusOK {
+		t.Fatalf("first exchange %d %s", status, errorCode)
+	}
+	if status, errorCode := o.exchange(t, exchangeForm(code)); status != http.StatusBadRequest || errorCode != oauth2ErrInvalidGrant {
+		t.Errorf("second exchange %d %s, want %d %s", status, errorCode, http.StatusBadRequest, oauth2ErrInvalidGrant)
+	}
+}
+
+func TestAuthorizationCodeFailedExchangeBurnsCode(t *testing.T) {
+	o := newOAuth2Test(t)
+	code := o.authorize(t)
+	form := exchangeForm(code)
+	form.Set("code_verifier", strings.Repeat("a", 43))
+	if status, _ := o.exchange(t, form); status != http.StatusBadRequest {
+		t.Fatalf("wrong verifier exchange %d", status)
+	}
+	if status, errorCode := o.exchange(t, exchangeForm(code)); errorCode != oauth2ErrInvalidGrant {
+		t.Errorf("exchange after a failed one %d %s, want %s", status, errorCode, oauth2ErrInvalidGrant)
+	}
+}
+
+func TestAuthorizationCodeExpired(t *testing.T) {
+	o := newOAuth2Test(t)
+	code := o.authorize(t)
+	for hash, stored := range o.codes.codes {
+		stored.ExpiresAt = time.Now().Add(-time.Second)
+		o.codes.codes[hash] = stored
+	}
+	if status, errorCode := o.exchange(t, exchangeForm(code)); status != http.StatusBadRequest || errorCode != oauth2ErrInvalidGrant {
+		t.Errorf("expired exchange %d %s", status, errorCode)
+	}
+}
+
+func (o oauth2Test) startDevice(t *testing.T) responses.DeviceAuthorizationResponse {
+	t.Helper()
+	form := url.Values{"client_id": {testClientId}, "scope": {"openid"}}
+	req := httptest.NewRequest(http.MethodPost, "/device/code", strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	resp := o.do(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("device authorization status %d", resp.StatusCode)
+	}
+	var body responses.DeviceAuthorizationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func pollForm(deviceCode string) url.Values {
+	return url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"client_id":   {testClientId},
+		"device_code": {deviceCode},
+	}
+}
+
+// enterUserCode looks the user code up on GET and decides it on POST, as the user with the id.
+func (o oauth2Test) enterUserCode(t *testing.T, method, userId, userCode string, approve bool) *http.Response {
+	t.Helper()
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, "/protected/device?"+url.Values{"user_code": {userCode}}.Encode(), nil)
+	} else {
+		body, err := json.Marshal(map[string]any{"user_code": userCode, "approve": approve})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = httptest.NewRequest(method, "/protected/device", strings.NewReader(string(body)))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer access-"+userId)
+	return o.do(t, req)
+}
+
+func (o oauth2Test) decide(t *testing.T, userCode string, approve bool) {
+	t.Helper()
+	if resp := o.enterUserCode(t, http.MethodPost, "1", userCode, approve); resp.StatusCode != http.StatusOK {
+		t.Fatalf("decision status %d", resp.StatusCode)
+	}
+}
+
+func TestDeviceFlowPolling(t *testing.T) {
+	tests := []struct {
+		name string
+		// before runs between the device authorization and the checked poll.
+		before    func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse)
+		change    func(form url.Values)
+		wantError string
+	}{
+		{
+			name:      "pending",
+			wantError: oauth2ErrAuthorizationPending,
+		},
+		{
+			name: "polled faster than the interval",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.exchange(t, pollForm(flow.DeviceCode))
+			},
+			wantError: oauth2ErrSlowDown,
+		},
+		{
+			name: "polled after the interval",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.exchange(t, pollForm(flow.DeviceCode))
+				o.devices.update(flow.DeviceCode, func(code *db.DeviceCode) {
+					code.LastPolledAt.Time = code.LastPolledAt.Time.Add(-deviceCodeInterval)
+				})
+			},
+			wantError: oauth2ErrAuthorizationPending,
+		},
+		{
+			name: "expired",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.devices.update(flow.DeviceCode, func(code *db.DeviceCode) {
+					code.ExpiresAt = time.Now().Add(-time.Second)
+				})
+			},
+			wantError: oauth2ErrExpiredToken,
+		},
+		{
+			name: "denied",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.decide(t, flow.UserCode, false)
+			},
+			wantError: oauth2ErrAccessDenied,
+		},
+		{
+			name: "approved",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.decide(t, flow.UserCode, true)
+			},
+		},
+		{
+			name: "approved with a user code typed loosely",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.decide(t, " "+strings.ToLower(strings.ReplaceAll(flow.UserCode, "-", ""))+" ", true)
+			},
+		},
+		{
+			name: "already exchanged",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.decide(t, flow.UserCode, true)
+				if status, errorCode := o.exchange(t, pollForm(flow.DeviceCode)); status != http.StatusOK {
+					t.Fatalf("first exchange %d %s", status, errorCode)
+				}
+			},
+			wantError: oauth2ErrInvalidGrant,
+		},
+		{
+			name: "other client",
+			before: func(t *testing.T, o oauth2Test, flow responses.DeviceAuthorizationResponse) {
+				o.decide(t, flow.UserCode, true)
+			},
+			change:    func(form url.Values) { form.Set("client_id", "other") },
+			wantError: oauth2ErrInvalidGrant,
+		},
+		{
+			name:      "unknown device code",
+			change:    func(form url.Values) { form.Set("device_code", "unknown") },
+			wantError: oauth2ErrInvalidGrant,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOAuth2Test(t)
+			flow := o.startDevice(t)
+			if tt.before != nil {
+				tt.before(t, o, flow)
+			}
+			form := pollForm(flow.DeviceCode)
+			if tt.change != nil {
+				tt.change(form)
+			}
+			status, errorCode := o.exchange(t, form)
+			if errorCode != tt.wantError {
+				t.Errorf("error %q, want %q", errorCode, tt.wantError)
+			}
+			wantStatus := http.StatusOK
+			if tt.wantError != "" {
+				wantStatus = http.StatusBadRequest
+			}
+			if status != wantStatus {
+				t.Errorf("status %d, want %d", status, wantStatus)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowSlowDownIncreasesInterval(t *testing.T) {
+	o := newOAuth2Test(t)
+	flow := o.startDevice(t)
+	for range 3 {
+		o.exchange(t, pollForm(flow.DeviceCode))
+	}
+	want := int64((deviceCodeInterval + 2*deviceCodeSlowDown).Seconds())
+	if got := o.devices.codes[opaque.Hash(flow.DeviceCode)].IntervalSeconds; got != want {
+		t.Errorf("interval %d, want %d", got, want)
+	}
+}
+
+func TestDeviceUserCodeBruteForce(t *testing.T) {
+	o := newOAuth2Test(t)
+	flow := o.startDevice(t)
+
+	for i := range userCodeMaxFailures {
+		method := http.MethodPost
+		if i%2 == 0 {
+			method = http.MethodGet
+		}
+		if resp := o.enterUserCode(t, method, "1", "BCDF-GHJK", true); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("wrong code %d status %d", i, resp.StatusCode)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		userId     string
+		method     string
+		userCode   string
+		wantStatus int
+	}{
+		{name: "wrong code", userId: "1", method: http.MethodPost, userCode: "BCDF-GHJK", wantStatus: http.StatusTooManyRequests},
+		{name: "right code", userId: "1", method: http.MethodGet, userCode: flow.UserCode, wantStatus: http.StatusTooManyRequests},
+		{name: "right code decided", userId: "1", method: http.MethodPost, userCode: flow.UserCode, wantStatus: http.StatusTooManyRequests},
+		{name: "other user", userId: "2", method: http.MethodGet, userCode: flow.UserCode, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := o.enterUserCode(t, tt.method, tt.userId, tt.userCode, true)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusTooManyRequests {
+				return
+			}
+			retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+			if err != nil || retryAfter <= 0 || retryAfter > int(userCodeFailureWindow.Seconds()) {
+				t.Errorf("retry after %q", resp.Header.Get(fiber.HeaderRetryAfter))
+			}
+		})
+	}
+
+	o.attempts.attempts[1] = db.UserCodeAttempts{
+		FailedAttempts:  o.attempts.attempts[1].FailedAttempts,
+		WindowStartedAt: time.Now().Add(-userCodeFailureWindow),
+	}
+	if resp := o.enterUserCode(t, http.MethodGet, "1", flow.UserCode, true); resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d after the window, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestDeviceUserCodeValidEntriesDontCount(t *testing.T) {
+	o := newOAuth2Test(t)
+	flow := o.startDevice(t)
+
+	for i := range 2*userCodeMaxFailures - 1 {
+		userCode := flow.UserCode
+		// Every other entry is wrong, the valid ones in between don't use up the failures.
+		if i%2 == 0 {
+			userCode = "BCDF-GHJK"
+		}
+		if resp := o.enterUserCode(t, http.MethodGet, "1", userCode, true); resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("entry %d refused", i)
+		}
+	}
+	if resp := o.enterUserCode(t, http.MethodGet, "1", flow.UserCode, true); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status %d after %d wrong codes, want %d", resp.StatusCode, userCodeMaxFailures, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	invitationDuration        = 7 * 24 * time.Hour
+	maxOrganizationNameLength = 100
+	organizationIdParam       = "org_id"
+	invitationTokenQueryParam = "token"
+)
+
+type (
+	organizationStore interface {
+		Create(ctx context.Context, name string, ownerId int64) (db.Organization, error)
+		GetById(ctx context.Context, id int64) (db.Organization, error)
+		ListByUser(ctx context.Context, userId int64) ([]db.Membership, error)
+		ListMembers(ctx context.Context, organizationId int64) ([]db.Membership, error)
+	}
+	invitationStore interface {
+		Insert(ctx context.Context, invitation db.Invitation) error
+		GetPendingByHash(ctx context.Context, hash string) (db.Invitation, error)
+		Accept(ctx context.Context, id, userId int64) error
+		Decline(ctx context.Context, id int64) error
+	}
+	tokenCreator interface {
+		CreateTokens(ctx context.Context, userId int64, auth jwt.Authentication) (jwt.Tokens, error)
+		RevokeSession(ctx context.Context, userId int64, sessionId string) error
+	}
+	emailSender interface {
+		SendTemplate(ctx context.Context, email mailer.Email) error
+	}
+)
+
+type OrgHandler struct {
+	organizationStore organizationStore
+	invitationStore   invitationStore
+	userGetterById    userGetterById
+	tokenCreator      tokenCreator
+	emailSender       emailSender
+	cookies           middlewares.TokenCookies
+	// invitationURL is the client page invitations are accepted or declined on, the token is added as a query parameter.
+	invitationURL string
+}
+
+func NewOrgHandler(
+	organizationStore organizationStore,
+	invitationStore invitationStore,
+	userGetterById userGetterById,
+	tokenCreator tokenCreator,
+	emailSender emailSender,
+	cookies middlewares.TokenCookies,
+	invitationURL string,
+) OrgHandler {
+	return OrgHandler{
+		organizationStore: organizationStore,
+		invitationStore:   invitationStore,
+		userGetterById:    userGetterById,
+		tokenCreator:      tokenCreator,
+		emailSender:       emailSender,
+		cookies:           cookies,
+		invitationURL:     invitationURL,
+	}
+}
+
+// Create creates an organization owned by the signed in user.
+func (h OrgHandler) Create(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.CreateOrganizationRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxOrganizationNameLength {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "name is required and must be at most 100 characters",
+		})
+	}
+
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	organization, err := h.organizationStore.Create(ctx, request.Name, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "organization not created",
+		})
+	}
+	return c.Status(http.StatusCreated).JSON(responses.Organization{
+		Id:        organization.Id,
+		Name:      organization.Name,
+		CreatedAt: organization.CreatedAt,
+	})
+}
+
+// List returns the organizations the signed in user is a member of.
+func (h OrgHandler) List(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	memberships, err := h.organizationStore.ListByUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(membershipsResponse(memberships))
+}
+
+// Switch issues tokens scoped to the organization in a new session replacing the current one,
+// the user must be its member.
+func (h OrgHandler) Switch(c fiber.Ctx) error {
+	ctx := c.Context()
+	orgId, err := strconv.ParseInt(c.Params(organizationIdParam), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "organization id is not valid",
+		})
+	}
+
+	claims := middlewares.TokenClaims(c)
+	if claims.IsPersonal() || claims.IsClient() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "only user sessions can switch organizations",
+		})
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	tokens, err := h.tokenCreator.CreateTokens(ctx, userId, jwt.Authentication{
+		Scope:     claims.Scopes(),
+		AuthTime:  claims.AuthTime.Time,
+		Methods:   claims.Amr,
+		ClientId:  claims.ClientId,
+		OrgId:     orgId,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IpAddress: c.IP(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+				Code:    http.StatusForbidden,
+				Message: "not a member of the organization",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	// The new session replaces the current one, its refresh tokens must not stay usable.
+	if err := h.tokenCreator.RevokeSession(ctx, userId, claims.SessionId); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+
+	return tokensResponse(c, h.cookies, tokens)
+}
+
+// Members lists the members of the organization the token is scoped to.
+func (h OrgHandler) Members(c fiber.Ctx) error {
+	ctx := c.Context()
+	memberships, err := h.organizationStore.ListMembers(ctx, middlewares.TokenClaims(c).OrgId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	return c.Status(http.StatusOK).JSON(membershipsResponse(memberships))
+}
+
+// Invite emails an invitation to the organization the token is scoped to.
+// Admins invite members, only owners can invite admins.
+func (h OrgHandler) Invite(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.InviteRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+	request.Email = strings.TrimSpace(request.Email)
+	if !strings.Contains(request.Email, "@") {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "email is not valid",
+		})
+	}
+	if request.Role != db.OrgRoleAdmin && request.Role != db.OrgRoleMember {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "role must be admin or member",
+		})
+	}
+
+	claims := middlewares.TokenClaims(c)
+	if request.Role == db.OrgRoleAdmin && claims.OrgRole != db.OrgRoleOwner {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "only owners can invite admins",
+		})
+	}
+	inviterId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+	inviter, err := h.userGetterById.GetById(ctx, inviterId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	organization, err := h.organizationStore.GetById(ctx, claims.OrgId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	token, err := opaque.Generate()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if err := h.invitationStore.Insert(ctx, db.Invitation{
+		OrganizationId: organization.Id,
+		Email:          request.Email,
+		Role:           request.Role,
+		TokenHash:      opaque.Hash(token),
+		InvitedBy:      sql.NullInt64{Int64: inviterId, Valid: true},
+		ExpiresAt:      time.Now().Add(invitationDuration),
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "invitation not created",
+		})
+	}
+
+	link := h.invitationURL + "?" + url.Values{invitationTokenQueryParam: {token}}.Encode()
+	if err := h.emailSender.SendTemplate(ctx, mailer.Email{
+		To:       request.Email,
+		Template: mailer.TemplateInvitation,
+		Locale:   c.Get(fiber.HeaderAcceptLanguage),
+		Data: mailer.InvitationData{
+			Inviter:      inviter.Login,
+			Organization: organization.Name,
+			Role:         request.Role,
+			Link:         link,
+		},
+	}); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "invitation not sent",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// AcceptInvitation makes the signed in user a member, the invitation must be addressed to the user's email.
+func (h OrgHandler) AcceptInvitation(c fiber.Ctx) error {
+	ctx := c.Context()
+	invitation, userId, ok, err := h.readInvitation(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.invitationStore.Accept(ctx, invitation.Id, userId); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return invitationError(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "accepted",
+	})
+}
+
+// DeclineInvitation declines the invitation addressed to the signed in user's email.
+func (h OrgHandler) DeclineInvitation(c fiber.Ctx) error {
+	ctx := c.Context()
+	invitation, _, ok, err := h.readInvitation(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.invitationStore.Decline(ctx, invitation.Id); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return invitationError(c, err)
+	}
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "declined",
+	})
+}
+
+// readInvitation returns the pending invitation from the request body addressed to the signed in user,
+// ok is false when the error response has been written already.
+func (h OrgHandler) readInvitation(c fiber.Ctx) (db.Invitation, int64, bool, error) {
+	ctx := c.Context()
+
+	var request requests.InvitationRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return db.Invitation{}, 0, false, c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return db.Invitation{}, 0, false, unauthorized(c)
+	}
+	user, err := h.userGetterById.GetById(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return db.Invitation{}, 0, false, c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	invitation, err := h.invitationStore.GetPendingByHash(ctx, opaque.Hash(request.Token))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return db.Invitation{}, 0, false, invitationError(c, err)
+	}
+	// An invitation not addressed to the user is reported as unknown, not to confirm the token exists.
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return db.Invitation{}, 0, false, invitationError(c, sql.ErrNoRows)
+	}
+	return invitation, userId, true, nil
+}
+
+func invitationError(c fiber.Ctx, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+			Code:    http.StatusNotFound,
+			Message: "invitation not found",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: "internal server error",
+	})
+}
+
+func membershipsResponse(memberships []db.Membership) []responses.Membership {
+	response := make([]responses.Membership, 0, len(memberships))
+	for _, membership := range memberships {
+		response = append(response, responses.Membership{
+			OrganizationId:   membership.OrganizationId,
+			OrganizationName: membership.OrganizationName,
+			UserId:           membership.UserId,
+			Login:            membership.Login,
+			Email:            membership.Email,
+			Role:             membership.Role,
+			CreatedAt:        membership.CreatedAt,
+		})
+	}
+	return response
+}
+
+func unauthorized(c fiber.Ctx) error {
+	return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+		Code:    http.StatusUnauthorized,
+		Message: "unauthorized",
+	})
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	passwordResetDuration          = time.Hour
+	passwordResetTokenQueryParam   = "token"
+	passwordResetRequestedResponse = "if the email belongs to an account, a reset link has been sent"
+)
+
+type (
+	userGetterByEmail interface {
+		GetByEmail(ctx context.Context, email string) (db.User, error)
+	}
+	passwordResetStore interface {
+		Insert(ctx context.Context, reset db.PasswordReset) error
+		GetPendingByHash(ctx context.Context, hash string) (db.PasswordReset, error)
+		Use(ctx context.Context, id int64, password string) error
+	}
+	sessionLogouter interface {
+		LogoutEverywhere(ctx context.Context, userId int64) error
+		LogoutOtherSessions(ctx context.Context, userId int64, keepSessionId string) error
+	}
+)
+
+// PasswordHandler changes passwords and recovers forgotten ones.
+type PasswordHandler struct {
+	userGetter         userGetterByEmail
+	userGetterById     userGetterById
+	passwordResetStore passwordResetStore
+	passwordUpdater    passwordUpdater
+	passwordHasher     passwordHasher
+	passwordPolicy     passwordPolicy
+	signInGuard        signInGuard
+	logouter           sessionLogouter
+	emailSender        emailSender
+	// resetURL is the client page new passwords are entered on, the token is added as a query parameter.
+	resetURL string
+}
+
+func NewPasswordHandler(
+	userGetter userGetterByEmail,
+	userGetterById userGetterById,
+	passwordResetStore passwordResetStore,
+	passwordUpdater passwordUpdater,
+	passwordHasher passwordHasher,
+	passwordPolicy passwordPolicy,
+	signInGuard signInGuard,
+	logouter sessionLogouter,
+	emailSender emailSender,
+	resetURL string,
+) PasswordHandler {
+	return PasswordHandler{
+		userGetter:         userGetter,
+		userGetterById:     userGetterById,
+		passwordResetStore: passwordResetStore,
+		passwordUpdater:    passwordUpdater,
+		passwordHasher:     passwordHasher,
+		passwordPolicy:     passwordPolicy,
+		signInGuard:        signInGuard,
+		logouter:           logouter,
+		emailSender:        emailSender,
+		resetURL:           resetURL,
+	}
+}
+
+// Change sets the new password of the signed in user, who confirms it with the current one.
+// The current password is guarded like a sign in, a stolen access token can't brute force it.
+// The other sessions are signed out unless the request keeps them, the current session stays signed in.
+func (h PasswordHandler) Change(c fiber.Ctx) error {
+	ctx := c.Context()
+	claims := middlewares.TokenClaims(c)
+	if claims.IsPersonal() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "personal access tokens can't change the password",
+		})
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	var request requests.ChangePasswordRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	user, err := h.userGetterById.GetById(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	attempt, ok, err := guardSignIn(c, h.signInGuard, user.Id, user.Login)
+	if !ok {
+		return err
+	}
+	valid, err := h.passwordHasher.Verify(user.Password, request.CurrentPassword)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if !valid {
+		signInFailed(c, h.signInGuard, attempt, user)
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "current password is incorrect",
+		})
+	}
+	signInSucceeded(c, h.signInGuard, attempt)
+	if ok, err := checkPassword(c, h.passwordPolicy, request.NewPassword, user.Login, user.Email); !ok {
+		return err
+	}
+
+	hashedPassword, err := h.passwordHasher.Hash(request.NewPassword)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if err := h.passwordUpdater.UpdatePassword(ctx, userId, hashedPassword); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "password not changed",
+		})
+	}
+
+	if !request.KeepOtherSessions {
+		if err := h.logouter.LogoutOtherSessions(ctx, userId, claims.SessionId); err != nil {
+			slog.ErrorContext(ctx, err.Error())
+			return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+				Code:    http.StatusInternalServerError,
+				Message: "other sessions not signed out",
+			})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// Forgot emails a password reset link. The response is the same whether the account exists or not,
+// failures after the user lookup are only logged so they don't tell accounts apart either.
+func (h PasswordHandler) Forgot(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.ForgotPasswordRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	user, err := h.userGetter.GetByEmail(ctx, strings.TrimSpace(request.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return passwordResetRequested(c)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	if err := h.sendResetLink(ctx, user, c.Get(fiber.HeaderAcceptLanguage)); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+	}
+	return passwordResetRequested(c)
+}
+
+func passwordResetRequested(c fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: passwordResetRequestedResponse,
+	})
+}
+
+func (h PasswordHandler) sendResetLink(ctx context.Context, user db.User, locale string) error {
+	token, err := opaque.Generate()
+	if err != nil {
+		return err
+	}
+	if err := h.passwordResetStore.Insert(ctx, db.PasswordReset{
+		UserId:    user.Id,
+		TokenHash: opaque.Hash(token),
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	}); err != nil {
+		return err
+	}
+
+	link := h.resetURL + "?" + url.Values{passwordResetTokenQueryParam: {token}}.Encode()
+	if err := h.emailSender.SendTemplate(ctx, mailer.Email{
+		To:       user.Email,
+		Template: mailer.TemplatePasswordReset,
+		Locale:   locale,
+		Data:     mailer.LinkData{Login: user.Login, Link: link},
+	}); err != nil {
+		return fmt.Errorf("send password reset: %w", err)
+	}
+	return nil
+}
+
+// Reset sets the new password with the token of the reset link and signs the user out everywhere.
+func (h PasswordHandler) Reset(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.ResetPasswordRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+
+	reset, err := h.passwordResetStore.GetPendingByHash(ctx, opaque.Hash(request.Token))
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return passwordResetError(c, err)
+	}
+	user, err := h.userGetterById.GetById(ctx, reset.UserId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+	// The token stays usable until a password meeting the policy is set.
+	if ok, err := checkPassword(c, h.passwordPolicy, request.Password, user.Login, user.Email); !ok {
+		return err
+	}
+	hashedPassword, err := h.passwordHasher.Hash(request.Password)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if err := h.passwordResetStore.Use(ctx, reset.Id, hashedPassword); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return passwordResetError(c, err)
+	}
+
+	if err := h.logouter.LogoutEverywhere(ctx, user.Id); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+// checkPassword checks the password against the policy, the broken rules are listed in the error response data.
+// ok is false when the error response has been written already.
+func checkPassword(c fiber.Ctx, policy passwordPolicy, newPassword string, personal ...string) (bool, error) {
+	violations, err := policy.Check(newPassword, personal...)
+	if err != nil {
+		slog.ErrorContext(c.Context(), err.Error())
+		return false, c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "internal server error",
+		})
+	}
+	if len(violations) == 0 {
+		return true, nil
+	}
+	data := make([]any, 0, len(violations))
+	for _, violation := range violations {
+		data = append(data, responses.PasswordViolation{
+			Rule:    violation.Rule,
+			Message: violation.Message,
+		})
+	}
+	return false, c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+		Code:    http.StatusBadRequest,
+		Message: "password does not meet the policy",
+		Data:    data,
+	})
+}
+
+func passwordResetError(c fiber.Ctx, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "reset token not valid",
+		})
+	}
+	return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: "internal server error",
+	})
+}
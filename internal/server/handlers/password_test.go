@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/lockout"
+	"github.com/antlko/goauth-boilerplate/internal/password"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (f fakeUsers) GetById(_ context.Context, id int64) (db.User, error) {
+	for _, user := range f {
+		if user.Id == id {
+			return user, nil
+		}
+	}
+	return db.User{}, sql.ErrNoRows
+}
+
+// recordingGuard refuses with refusal and counts how the attempts it let through ended.
+type recordingGuard struct {
+	refusal   error
+	failed    int
+	succeeded int
+}
+
+func (g *recordingGuard) Begin(context.Context, int64, string, string) (lockout.Attempt, time.Duration, error) {
+	if g.refusal != nil {
+		return lockout.Attempt{}, time.Minute, g.refusal
+	}
+	return lockout.Attempt{}, 0, nil
+}
+
+func (g *recordingGuard) Failed(context.Context, lockout.Attempt, db.User, string) error {
+	g.failed++
+	return nil
+}
+
+func (g *recordingGuard) Succeeded(context.Context, lockout.Attempt) error {
+	g.succeeded++
+	return nil
+}
+
+// brokenHasher fails to verify hashes, like a corrupt stored hash does.
+type brokenHasher struct {
+	fakeHasher
+}
+
+func (brokenHasher) Verify(string, string) (bool, error) {
+	return false, errors.New("hash not valid")
+}
+
+type fakePasswordPolicy struct{}
+
+func (fakePasswordPolicy) Check(string, ...string) ([]password.Violation, error) {
+	return nil, nil
+}
+
+type fakePasswordUpdater struct {
+	updated map[int64]string
+}
+
+func (f fakePasswordUpdater) UpdatePassword(_ context.Context, id int64, password string) error {
+	f.updated[id] = password
+	return nil
+}
+
+type fakeLogouter struct{}
+
+func (fakeLogouter) LogoutEverywhere(context.Context, int64) error {
+	return nil
+}
+
+func (fakeLogouter) LogoutOtherSessions(context.Context, int64, string) error {
+	return nil
+}
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		name           string
+		hasher         passwordHasher
+		refusal        error
+		current        string
+		wantStatus     int
+		wantFailed     int
+		wantSucceeded  int
+		wantRetryAfter string
+		wantChanged    bool
+	}{
+		{
+			name:          "current password",
+			hasher:        fakeHasher{},
+			current:       testPassword,
+			wantStatus:    http.StatusOK,
+			wantSucceeded: 1,
+			wantChanged:   true,
+		},
+		{
+			name:       "wrong current password",
+			hasher:     fakeHasher{},
+			current:    "guess",
+			wantStatus: http.StatusForbidden,
+			wantFailed: 1,
+		},
+		{
+			name:           "refused by the guard",
+			hasher:         fakeHasher{},
+			refusal:        lockout.ErrBackoff,
+			current:        testPassword,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name:           "account locked",
+			hasher:         fakeHasher{},
+			refusal:        lockout.ErrLocked,
+			current:        testPassword,
+			wantStatus:     http.StatusTooManyRequests,
+			wantRetryAfter: "60",
+		},
+		{
+			name:       "hasher error",
+			hasher:     brokenHasher{},
+			current:    testPassword,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies, err := middlewares.NewTokenCookies(middlewares.CookieConfig{CookieSameSite: "lax"}, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			guard := &recordingGuard{refusal: tt.refusal}
+			updater := fakePasswordUpdater{updated: map[int64]string{}}
+			h := NewPasswordHandler(
+				fakeUsers{},
+				fakeUsers{{Id: 1, Login: testLogin, Email: "alice@example.com", Password: "hash:" + testPassword}},
+				nil,
+				updater,
+				tt.hasher,
+				fakePasswordPolicy{},
+				guard,
+				fakeLogouter{},
+				nil,
+				"https://example.com/reset",
+			)
+			app := fiber.New()
+			app.Post("/password", h.Change, middlewares.BearerVerifier(fakeTokenValidator{}, cookies))
+
+			body, err := json.Marshal(map[string]any{"current_password": tt.current, "new_password": "Another-Horse-43"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/password", strings.NewReader(string(body)))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer access-1")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+			if guard.failed != tt.wantFailed || guard.succeeded != tt.wantSucceeded {
+				t.Errorf("%d failed and %d succeeded attempts, want %d and %d",
+					guard.failed, guard.succeeded, tt.wantFailed, tt.wantSucceeded)
+			}
+			if _, changed := updater.updated[1]; changed != tt.wantChanged {
+				t.Errorf("password changed %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+)
+
+type (
+	userSessionRevoker interface {
+		RevokeSession(ctx context.Context, userId int64, sessionId string) error
+	}
+	sessionStore interface {
+		ListActiveByUser(ctx context.Context, userId int64) ([]db.Session, error)
+	}
+)
+
+type SessionHandler struct {
+	userSessionRevoker userSessionRevoker
+	sessionStore       sessionStore
+}
+
+func NewSessionHandler(userSessionRevoker userSessionRevoker, sessionStore sessionStore) SessionHandler {
+	return SessionHandler{
+		userSessionRevoker: userSessionRevoker,
+		sessionStore:       sessionStore,
+	}
+}
+
+// List returns the active sessions of the signed in user.
+func (h SessionHandler) List(c fiber.Ctx) error {
+	ctx := c.Context()
+	claims := middlewares.TokenClaims(c)
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	sessions, err := h.sessionStore.ListActiveByUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	response := make([]responses.Session, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, responses.Session{
+			Id:         session.Id,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			Current:    session.Id == claims.SessionId,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+		})
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Revoke signs the user out of the session, its refresh and access tokens stop working.
+func (h SessionHandler) Revoke(c fiber.Ctx) error {
+	ctx := c.Context()
+	claims := middlewares.TokenClaims(c)
+	if claims.IsPersonal() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "personal access tokens can't revoke sessions",
+		})
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return unauthorized(c)
+	}
+
+	if err := h.userSessionRevoker.RevokeSession(ctx, userId, c.Params("id")); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+				Code:    http.StatusNotFound,
+				Message: "session not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "session not revoked",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
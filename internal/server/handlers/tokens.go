@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/server/requests"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPersonalAccessTokenNameLength = 100
+
+type (
+	personalAccessTokenIssuer interface {
+		CreatePersonalAccessToken(
+			ctx context.Context,
+			userId int64,
+			name string,
+			scope []string,
+			expiresAt time.Time,
+		) (string, db.PersonalAccessToken, error)
+		GrantAllowedScopes(requested string, allowed []string) []string
+	}
+	personalAccessTokenStore interface {
+		ListByUser(ctx context.Context, userId int64) ([]db.PersonalAccessToken, error)
+		Revoke(ctx context.Context, userId, id int64) error
+	}
+)
+
+type PersonalAccessTokenHandler struct {
+	personalAccessTokenIssuer personalAccessTokenIssuer
+	personalAccessTokenStore  personalAccessTokenStore
+}
+
+func NewPersonalAccessTokenHandler(
+	personalAccessTokenIssuer personalAccessTokenIssuer,
+	personalAccessTokenStore personalAccessTokenStore,
+) PersonalAccessTokenHandler {
+	return PersonalAccessTokenHandler{
+		personalAccessTokenIssuer: personalAccessTokenIssuer,
+		personalAccessTokenStore:  personalAccessTokenStore,
+	}
+}
+
+func (h PersonalAccessTokenHandler) List(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+
+	tokens, err := h.personalAccessTokenStore.ListByUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	response := make([]responses.PersonalAccessToken, 0, len(tokens))
+	for _, token := range tokens {
+		response = append(response, personalAccessTokenResponse(token))
+	}
+	return c.Status(http.StatusOK).JSON(response)
+}
+
+// Create issues a personal access token, the token is returned only once. Its scope is bounded by the scope
+// of the caller's token, personal access tokens and tokens issued to oauth2 clients can't create other ones.
+func (h PersonalAccessTokenHandler) Create(c fiber.Ctx) error {
+	ctx := c.Context()
+
+	var request requests.CreatePersonalAccessTokenRequest
+	if err := json.Unmarshal(c.Body(), &request); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "request body not parsed",
+		})
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxPersonalAccessTokenNameLength {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "name is required and must be at most 100 characters",
+		})
+	}
+	var expiresAt time.Time
+	if request.ExpiresAt != nil {
+		if !request.ExpiresAt.After(time.Now()) {
+			return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+				Code:    http.StatusBadRequest,
+				Message: "expires_at must be in the future",
+			})
+		}
+		expiresAt = *request.ExpiresAt
+	}
+
+	claims := middlewares.TokenClaims(c)
+	if claims.IsPersonal() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "personal access tokens can't create tokens",
+		})
+	}
+	if claims.ClientId != "" {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "tokens issued to clients can't create tokens",
+		})
+	}
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+
+	scope := h.personalAccessTokenIssuer.GrantAllowedScopes(request.Scope, claims.Scopes())
+	if len(scope) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "none of the requested scopes can be granted",
+		})
+	}
+
+	token, stored, err := h.personalAccessTokenIssuer.CreatePersonalAccessToken(
+		ctx,
+		userId,
+		request.Name,
+		scope,
+		expiresAt,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "token not created",
+		})
+	}
+
+	return c.Status(http.StatusCreated).JSON(responses.CreatedPersonalAccessToken{
+		Token:               token,
+		PersonalAccessToken: personalAccessTokenResponse(stored),
+	})
+}
+
+func (h PersonalAccessTokenHandler) Revoke(c fiber.Ctx) error {
+	ctx := c.Context()
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(responses.ErrorResponse{
+			Code:    http.StatusBadRequest,
+			Message: "token id is not valid",
+		})
+	}
+
+	if err := h.personalAccessTokenStore.Revoke(ctx, userId, id); err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.Status(http.StatusNotFound).JSON(responses.ErrorResponse{
+				Code:    http.StatusNotFound,
+				Message: "token not found",
+			})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "token not revoked",
+		})
+	}
+
+	return c.Status(http.StatusOK).JSON(responses.StatusResponse{
+		Status: "ok",
+	})
+}
+
+func personalAccessTokenResponse(token db.PersonalAccessToken) responses.PersonalAccessToken {
+	response := responses.PersonalAccessToken{
+		Id:          token.Id,
+		Name:        token.Name,
+		TokenPrefix: token.TokenPrefix,
+		Scope:       token.Scope,
+		CreatedAt:   token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		response.ExpiresAt = &token.ExpiresAt.Time
+	}
+	if token.LastUsedAt.Valid {
+		response.LastUsedAt = &token.LastUsedAt.Time
+	}
+	return response
+}
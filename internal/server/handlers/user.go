@@ -3,30 +3,40 @@ package handlers
 import (
 	"context"
 	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
 	"github.com/antlko/goauth-boilerplate/internal/server/responses"
 	"github.com/gofiber/fiber/v3"
 	"log/slog"
 	"net/http"
 )
 
-type userGetterByLogin interface {
-	GetByLogin(ctx context.Context, login string) (db.User, error)
+type userGetterById interface {
+	GetById(ctx context.Context, id int64) (db.User, error)
 }
 
 type UserHandler struct {
-	userGetterByLogin userGetterByLogin
+	userGetterById userGetterById
 }
 
-func NewUserHandler(userGetterByLogin userGetterByLogin) UserHandler {
+func NewUserHandler(userGetterById userGetterById) UserHandler {
 	return UserHandler{
-		userGetterByLogin: userGetterByLogin,
+		userGetterById: userGetterById,
 	}
 }
 
 func (h UserHandler) GetUser(c fiber.Ctx) error {
 	ctx := c.Context()
-	login := c.GetReqHeaders()["X-User-Id"][0]
-	user, err := h.userGetterByLogin.GetByLogin(ctx, login)
+	userId, err := middlewares.TokenClaims(c).UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+
+	user, err := h.userGetterById.GetById(ctx, userId)
 	if err != nil {
 		slog.ErrorContext(ctx, err.Error())
 		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
@@ -35,8 +45,41 @@ func (h UserHandler) GetUser(c fiber.Ctx) error {
 		})
 	}
 	return c.Status(http.StatusOK).JSON(responses.User{
-		Id:    user.Id,
-		Login: user.Login,
-		Email: user.Email,
+		Id:            user.Id,
+		Login:         user.Login,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	})
+}
+
+// UserInfo is the OpenID Connect userinfo endpoint, claims are released by the token scopes.
+// It is routed behind RequireScope(jwt.ScopeOpenId).
+func (h UserHandler) UserInfo(c fiber.Ctx) error {
+	ctx := c.Context()
+	claims := middlewares.TokenClaims(c)
+	userId, err := claims.UserId()
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
+			Code:    http.StatusUnauthorized,
+			Message: "unauthorized",
+		})
+	}
+
+	user, err := h.userGetterById.GetById(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, err.Error())
+		return c.Status(http.StatusInternalServerError).JSON(responses.ErrorResponse{
+			Code:    http.StatusInternalServerError,
+			Message: "can't make a fetch",
+		})
+	}
+
+	info := jwt.NewUserInfo(user, claims.Scopes())
+	return c.Status(http.StatusOK).JSON(responses.UserInfo{
+		Subject:           claims.Subject,
+		PreferredUsername: info.PreferredUsername,
+		Email:             info.Email,
+		EmailVerified:     info.EmailVerified,
 	})
 }
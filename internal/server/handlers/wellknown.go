@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/oauth2/pkce"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"strings"
+)
+
+type keysProvider interface {
+	JWKS() jwt.JWKS
+	SigningAlgorithm() string
+	SupportedScopes() []string
+}
+
+type WellKnownHandler struct {
+	keysProvider keysProvider
+	issuer       string
+}
+
+func NewWellKnownHandler(keysProvider keysProvider, issuer string) WellKnownHandler {
+	return WellKnownHandler{
+		keysProvider: keysProvider,
+		issuer:       strings.TrimSuffix(issuer, "/"),
+	}
+}
+
+func (h WellKnownHandler) JWKS(c fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(http.StatusOK).JSON(h.keysProvider.JWKS())
+}
+
+func (h WellKnownHandler) OpenIDConfiguration(c fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=3600")
+	return c.Status(http.StatusOK).JSON(responses.OpenIDConfiguration{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + "/api/v1/oauth2/authorize",
+		TokenEndpoint:                     h.issuer + "/api/v1/oauth2/token",
+		IntrospectionEndpoint:             h.issuer + "/api/v1/oauth2/introspect",
+		RevocationEndpoint:                h.issuer + "/api/v1/oauth2/revoke",
+		DeviceAuthorizationEndpoint:       h.issuer + "/api/v1/oauth2/device/code",
+		JwksURI:                           h.issuer + "/.well-known/jwks.json",
+		UserinfoEndpoint:                  h.issuer + "/api/v1/oauth2/userinfo",
+		ScopesSupported:                   h.keysProvider.SupportedScopes(),
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", deviceCodeGrantType},
+		CodeChallengeMethodsSupported:     []string{pkce.MethodS256},
+		TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{h.keysProvider.SigningAlgorithm()},
+		ClaimsSupported: []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr",
+			"preferred_username", "email", "email_verified",
+		},
+	})
+}
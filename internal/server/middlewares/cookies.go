@@ -0,0 +1,141 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cookies set in cookie mode, the CSRF token is echoed by the client in CsrfTokenHeader.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CsrfTokenCookie    = "csrf_token"
+	CsrfTokenHeader    = "X-CSRF-Token"
+)
+
+// refreshTokenCookiePath keeps the refresh token away from every endpoint but the auth ones consuming it.
+const refreshTokenCookiePath = "/api/v1/auth"
+
+type CookieConfig struct {
+	// CookieMode sets tokens in HttpOnly cookies instead of response bodies, it is meant for browser apps.
+	CookieMode   bool   `env:"COOKIE_MODE"`
+	CookieDomain string `env:"COOKIE_DOMAIN"`
+	// CookieSameSite is lax, strict or none, none is needed when the client is on another site than the server.
+	CookieSameSite string `env:"COOKIE_SAME_SITE, default=lax"`
+}
+
+// TokenCookies sets and reads the token cookies of cookie mode and guards them with a double-submit CSRF token.
+type TokenCookies struct {
+	config          CookieConfig
+	accessDuration  time.Duration
+	refreshDuration time.Duration
+}
+
+func NewTokenCookies(config CookieConfig, accessDuration, refreshDuration time.Duration) (TokenCookies, error) {
+	switch strings.ToLower(config.CookieSameSite) {
+	case fiber.CookieSameSiteLaxMode, fiber.CookieSameSiteStrictMode, fiber.CookieSameSiteNoneMode:
+	default:
+		return TokenCookies{}, fmt.Errorf("unsupported cookie same site mode %q", config.CookieSameSite)
+	}
+	return TokenCookies{
+		config:          config,
+		accessDuration:  accessDuration,
+		refreshDuration: refreshDuration,
+	}, nil
+}
+
+// Enabled reports whether cookie mode is on.
+func (t TokenCookies) Enabled() bool {
+	return t.config.CookieMode
+}
+
+// Set sets the token cookies and returns the CSRF token, which is issued when the request has none yet.
+// The token is returned for clients on another origin, they can't read the cookie.
+func (t TokenCookies) Set(c fiber.Ctx, accessToken, refreshToken string) (string, error) {
+	csrfToken, err := t.CsrfToken(c)
+	if err != nil {
+		return "", err
+	}
+	c.Cookie(t.cookie(AccessTokenCookie, accessToken, "/", t.accessDuration, true))
+	c.Cookie(t.cookie(RefreshTokenCookie, refreshToken, refreshTokenCookiePath, t.refreshDuration, true))
+	return csrfToken, nil
+}
+
+// CsrfToken returns the CSRF token of the request, a new one is issued when there is none.
+func (t TokenCookies) CsrfToken(c fiber.Ctx) (string, error) {
+	if csrfToken := c.Cookies(CsrfTokenCookie); csrfToken != "" {
+		return strings.Clone(csrfToken), nil
+	}
+	csrfToken, err := opaque.Generate()
+	if err != nil {
+		return "", fmt.Errorf("generate csrf token: %w", err)
+	}
+	c.Cookie(t.cookie(CsrfTokenCookie, csrfToken, "/", t.refreshDuration, false))
+	return csrfToken, nil
+}
+
+// Clear expires the token and CSRF cookies.
+func (t TokenCookies) Clear(c fiber.Ctx) {
+	c.Cookie(t.cookie(AccessTokenCookie, "", "/", 0, true))
+	c.Cookie(t.cookie(RefreshTokenCookie, "", refreshTokenCookiePath, 0, true))
+	c.Cookie(t.cookie(CsrfTokenCookie, "", "/", 0, false))
+}
+
+// AccessToken returns the access token cookie, empty when cookie mode is off.
+func (t TokenCookies) AccessToken(c fiber.Ctx) string {
+	if !t.Enabled() {
+		return ""
+	}
+	return c.Cookies(AccessTokenCookie)
+}
+
+// RefreshToken returns the refresh token cookie, empty when cookie mode is off.
+func (t TokenCookies) RefreshToken(c fiber.Ctx) string {
+	if !t.Enabled() {
+		return ""
+	}
+	return c.Cookies(RefreshTokenCookie)
+}
+
+// ValidCsrf reports whether a state-changing request echoes the CSRF cookie in CsrfTokenHeader,
+// it must be checked whenever a token is taken from a cookie.
+func (t TokenCookies) ValidCsrf(c fiber.Ctx) bool {
+	switch c.Method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return equalCsrf(c.Cookies(CsrfTokenCookie), c.Get(CsrfTokenHeader))
+}
+
+// ValidCsrfForm reports whether a form post echoes the CSRF cookie in the CsrfTokenCookie field,
+// it guards server rendered forms, which can't set headers.
+func (t TokenCookies) ValidCsrfForm(c fiber.Ctx) bool {
+	return equalCsrf(c.Cookies(CsrfTokenCookie), c.FormValue(CsrfTokenCookie))
+}
+
+func equalCsrf(cookie, echoed string) bool {
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(echoed)) == 1
+}
+
+// cookie builds a Secure cookie, a zero duration expires it.
+func (t TokenCookies) cookie(name, value, path string, duration time.Duration, httpOnly bool) *fiber.Cookie {
+	cookie := &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   t.config.CookieDomain,
+		MaxAge:   int(duration.Seconds()),
+		Secure:   true,
+		HTTPOnly: httpOnly,
+		SameSite: t.config.CookieSameSite,
+	}
+	if duration == 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	return cookie
+}
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+)
+
+// RequireVerifiedEmail rejects the restricted tokens of users who haven't verified their email,
+// it must run after BearerVerifier.
+func RequireVerifiedEmail(c fiber.Ctx) error {
+	if TokenClaims(c).HasScope(jwt.ScopeUnverifiedEmail) {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "email not verified",
+		})
+	}
+	return c.Next()
+}
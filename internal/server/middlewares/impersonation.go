@@ -0,0 +1,19 @@
+package middlewares
+
+import (
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+)
+
+// RejectImpersonation guards routes changing credentials or sessions of the user against impersonation tokens,
+// it must run after BearerVerifier.
+func RejectImpersonation(c fiber.Ctx) error {
+	if TokenClaims(c).IsImpersonated() {
+		return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+			Code:    http.StatusForbidden,
+			Message: "not allowed while impersonating",
+		})
+	}
+	return c.Next()
+}
@@ -17,7 +17,10 @@ func Logger(c fiber.Ctx) error {
 		slog.ErrorContext(c.Context(), fmt.Sprintf("server request error: %s", err.Error()))
 	}
 
-	body := hidePII(ctx, c.Body())
+	var body string
+	if c.Is("json") {
+		body = hidePII(ctx, c.Body())
+	}
 
 	slog.InfoContext(ctx,
 		"new http request",
@@ -37,7 +40,7 @@ func hidePII(ctx context.Context, data []byte) string {
 	}
 	result := make(map[string]any)
 	if err := json.Unmarshal(data, &result); err != nil {
-		slog.ErrorContext(ctx, "unmarshalling request body", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("unmarshalling request body: %s", err.Error()))
 		return body
 	}
 	if len(result) > 0 {
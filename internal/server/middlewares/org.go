@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"slices"
+	"strconv"
+)
+
+// RequireOrg rejects tokens scoped to another organization than the one in the param route parameter,
+// it must run after BearerVerifier.
+func RequireOrg(param string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		orgId, err := strconv.ParseInt(c.Params(param), 10, 64)
+		if err != nil || orgId != TokenClaims(c).OrgId {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+// RequireOrgRole lets through tokens whose organization role is any of roles, it must run after RequireOrg.
+func RequireOrgRole(roles ...string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		if !slices.Contains(roles, TokenClaims(c).OrgRole) {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"slices"
+)
+
+// RequireRole lets through tokens of users having any of roles, it must run after BearerVerifier.
+func RequireRole(roles ...string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		if !slices.ContainsFunc(roles, TokenClaims(c).HasRole) {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+// RequirePermission lets through tokens of users granted every one of permissions, it must run after BearerVerifier.
+func RequirePermission(permissions ...string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		claims := TokenClaims(c)
+		for _, permission := range permissions {
+			if !claims.HasPermission(permission) {
+				return forbidden(c)
+			}
+		}
+		return c.Next()
+	}
+}
+
+func forbidden(c fiber.Ctx) error {
+	return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+		Code:    http.StatusForbidden,
+		Message: "forbidden",
+	})
+}
@@ -0,0 +1,27 @@
+package middlewares
+
+import (
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/server/responses"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"strings"
+)
+
+// RequireScope lets through tokens granted every one of scopes, it must run after BearerVerifier.
+func RequireScope(scopes ...string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		claims := TokenClaims(c)
+		for _, scope := range scopes {
+			if claims.HasScope(scope) {
+				continue
+			}
+			c.Set(fiber.HeaderWWWAuthenticate, fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+			return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+				Code:    http.StatusForbidden,
+				Message: "insufficient_scope",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withScope stands in for BearerVerifier, it accepts the request with a token granted scope.
+func withScope(scope string) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		c.Locals(tokenClaimsKey, jwt.Claims{TokenUse: jwt.TokenUseAccess, Scope: scope})
+		return c.Next()
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		granted       string
+		required      []string
+		wantStatus    int
+		wantChallenge string
+	}{
+		{name: "granted", granted: "api:read", required: []string{"api:read"}, wantStatus: http.StatusOK},
+		{name: "superset granted", granted: "openid api:read api:write", required: []string{"api:read", "api:write"}, wantStatus: http.StatusOK},
+		{name: "nothing required", granted: "", wantStatus: http.StatusOK},
+		{
+			name:          "subset granted",
+			granted:       "api:read",
+			required:      []string{"api:read", "api:write"},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="api:read api:write"`,
+		},
+		{
+			name:          "nothing granted",
+			granted:       "",
+			required:      []string{"api:read"},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="api:read"`,
+		},
+		{
+			name:          "scope prefix granted",
+			granted:       "api",
+			required:      []string{"api:read"},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="api:read"`,
+		},
+		{
+			name:          "restricted scope of an unverified user",
+			granted:       "openid " + jwt.ScopeUnverifiedEmail,
+			required:      []string{"api:read"},
+			wantStatus:    http.StatusForbidden,
+			wantChallenge: `Bearer error="insufficient_scope", scope="api:read"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			}, withScope(tt.granted), RequireScope(tt.required...))
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderWWWAuthenticate); got != tt.wantChallenge {
+				t.Errorf("challenge %q, want %q", got, tt.wantChallenge)
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name       string
+		granted    string
+		wantStatus int
+	}{
+		{name: "verified", granted: "openid api:read", wantStatus: http.StatusOK},
+		{name: "no scope", granted: "", wantStatus: http.StatusOK},
+		{name: "unverified", granted: "openid " + jwt.ScopeUnverifiedEmail, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			}, withScope(tt.granted), RequireVerifiedEmail)
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
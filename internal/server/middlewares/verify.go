@@ -1,48 +1,67 @@
 package middlewares
 
 import (
+	"context"
+	"github.com/antlko/goauth-boilerplate/internal/jwt"
 	"github.com/antlko/goauth-boilerplate/internal/server/responses"
 	"github.com/gofiber/fiber/v3"
 	"net/http"
 )
 
+const tokenClaimsKey = "token_claims"
+
 type tokenValidator interface {
-	Validate(token string) (bool, string, error)
+	ValidateAccess(ctx context.Context, token string) (jwt.Claims, error)
 }
 
-func BearerVerifier(tokenValidator tokenValidator) func(c fiber.Ctx) error {
+// BearerVerifier accepts the access token of the Authorization header or, in cookie mode, of the access token cookie.
+// Cookie authenticated state-changing requests must carry the CSRF token.
+func BearerVerifier(tokenValidator tokenValidator, cookies TokenCookies) func(c fiber.Ctx) error {
 	return func(c fiber.Ctx) error {
-		authHeaders, ok := c.GetReqHeaders()["Authorization"]
-		if !ok {
+		token, fromCookie := bearerToken(c, cookies)
+		if token == "" {
 			return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
 				Code:    http.StatusUnauthorized,
 				Message: "bad auth header",
 			})
 		}
-		if len(authHeaders) == 0 {
-			return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
-				Code:    http.StatusUnauthorized,
-				Message: "bad auth header",
+		if fromCookie && !cookies.ValidCsrf(c) {
+			return c.Status(http.StatusForbidden).JSON(responses.ErrorResponse{
+				Code:    http.StatusForbidden,
+				Message: "csrf token not valid",
 			})
 		}
-		authHeader := authHeaders[0]
-		if len(authHeader) < 10 {
-			return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
-				Code:    http.StatusUnauthorized,
-				Message: "bad auth header",
-			})
-		}
-		authHeader = authHeader[len("Bearer "):]
 
-		isValid, username, err := tokenValidator.Validate(authHeader)
-		if err != nil || !isValid {
+		claims, err := tokenValidator.ValidateAccess(c.Context(), token)
+		if err != nil {
 			return c.Status(http.StatusUnauthorized).JSON(responses.ErrorResponse{
 				Code:    http.StatusUnauthorized,
 				Message: "token not valid",
 			})
 		}
 
-		c.Request().Header.Add("X-User-Id", username)
+		c.Locals(tokenClaimsKey, claims)
 		return c.Next()
 	}
 }
+
+// bearerToken returns the presented access token, empty when there is none. The cookie is read only
+// without an Authorization header, fromCookie tells the caller to check the CSRF token.
+func bearerToken(c fiber.Ctx, cookies TokenCookies) (token string, fromCookie bool) {
+	authHeaders, ok := c.GetReqHeaders()["Authorization"]
+	if !ok || len(authHeaders) == 0 {
+		return cookies.AccessToken(c), true
+	}
+	authHeader := authHeaders[0]
+	if len(authHeader) < 10 {
+		return "", false
+	}
+	return authHeader[len("Bearer "):], false
+}
+
+// TokenClaims returns claims of the token accepted by BearerVerifier,
+// Claims.IsClient tells services authenticated with client credentials apart from users.
+func TokenClaims(c fiber.Ctx) jwt.Claims {
+	claims, _ := c.Locals(tokenClaimsKey).(jwt.Claims)
+	return claims
+}
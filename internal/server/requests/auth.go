@@ -9,8 +9,47 @@ type SignUpRequest struct {
 type SignInRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	// Scope is space separated, openid adds an id token to the response.
+	Scope string `json:"scope"`
+	Nonce string `json:"nonce"`
 }
 
 type VerifyAndRefreshRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ChangePasswordRequest sets NewPassword, the other sessions of the user are signed out unless KeepOtherSessions is set.
+type ChangePasswordRequest struct {
+	CurrentPassword   string `json:"current_password"`
+	NewPassword       string `json:"new_password"`
+	KeepOtherSessions bool   `json:"keep_other_sessions"`
+}
+
+// ResetPasswordRequest sets Password with the token of the password reset link.
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// DeviceDecisionRequest approves or, when Approve is false, denies the device flow of UserCode.
+type DeviceDecisionRequest struct {
+	UserCode string `json:"user_code"`
+	Approve  bool   `json:"approve"`
+}
+
+// UnlockAccountRequest clears the lockout with the token of the emailed unlock link.
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
@@ -0,0 +1,15 @@
+package requests
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email"`
+	// Role is admin or member.
+	Role string `json:"role"`
+}
+
+type InvitationRequest struct {
+	Token string `json:"token"`
+}
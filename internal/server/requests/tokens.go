@@ -0,0 +1,11 @@
+package requests
+
+import "time"
+
+type CreatePersonalAccessTokenRequest struct {
+	Name string `json:"name"`
+	// Scope is space separated.
+	Scope string `json:"scope"`
+	// ExpiresAt is optional, the token is valid until revoked without it.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
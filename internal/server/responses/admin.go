@@ -0,0 +1,24 @@
+package responses
+
+import "time"
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRoles struct {
+	UserId int64    `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+// Lockout is a user whose sign ins are delayed or, until LockedUntil, locked.
+type Lockout struct {
+	UserId         int64      `json:"user_id"`
+	Login          string     `json:"login"`
+	Email          string     `json:"email"`
+	FailedAttempts int        `json:"failed_attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+}
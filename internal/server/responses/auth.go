@@ -3,6 +3,23 @@ package responses
 type TokensResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token,omitempty"` // Optional (from Auth0 doc refreshing access/refresh tokens)
+	IdToken      string `json:"id_token,omitempty"`
+}
+
+// CookieTokensResponse is returned in cookie mode, the access and refresh tokens are set in HttpOnly cookies.
+type CookieTokensResponse struct {
+	CsrfToken string `json:"csrf_token"`
+	IdToken   string `json:"id_token,omitempty"`
+}
+
+type CsrfResponse struct {
+	CsrfToken string `json:"csrf_token"`
+}
+
+// PasswordViolation is a broken password policy rule, listed in the data of the error response.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 type Oauth2Response struct {
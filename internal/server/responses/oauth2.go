@@ -0,0 +1,64 @@
+package responses
+
+type OAuth2TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// TokenExchangeResponse is the RFC 8693 token exchange response.
+type TokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+// OAuth2ErrorResponse is the RFC 6749 error format expected by oauth2 client libraries.
+type OAuth2ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response, durations are in seconds.
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceResponse describes the pending device flow of a user code.
+type DeviceResponse struct {
+	ClientName string `json:"client_name"`
+	Scope      string `json:"scope"`
+}
+
+// IntrospectionResponse is the RFC 7662 introspection result, only Active is set for inactive tokens.
+type IntrospectionResponse struct {
+	Active bool `json:"active"`
+	// TokenType is access_token, refresh_token or personal_access_token.
+	TokenType   string   `json:"token_type,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	ClientId    string   `json:"client_id,omitempty"`
+	Subject     string   `json:"sub,omitempty"`
+	SubjectType string   `json:"sub_type,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	Issuer      string   `json:"iss,omitempty"`
+	Audience    []string `json:"aud,omitempty"`
+	JwtId       string   `json:"jti,omitempty"`
+	// Actor names the admin impersonating the subject.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+type Actor struct {
+	Subject string `json:"sub"`
+}
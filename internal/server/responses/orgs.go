@@ -0,0 +1,19 @@
+package responses
+
+import "time"
+
+type Organization struct {
+	Id        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Membership struct {
+	OrganizationId   int64     `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	UserId           int64     `json:"user_id"`
+	Login            string    `json:"login"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	CreatedAt        time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package responses
+
+import "time"
+
+type Session struct {
+	Id        string `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IpAddress string `json:"ip_address"`
+	// Current marks the session the request is made with.
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
@@ -0,0 +1,19 @@
+package responses
+
+import "time"
+
+type PersonalAccessToken struct {
+	Id          int64      `json:"id"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"`
+	Scope       string     `json:"scope"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// CreatedPersonalAccessToken is the only response the token itself is ever returned in.
+type CreatedPersonalAccessToken struct {
+	Token string `json:"token"`
+	PersonalAccessToken
+}
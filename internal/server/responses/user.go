@@ -1,9 +1,10 @@
 package responses
 
 type User struct {
-	Id    int64  `json:"id"`
-	Login string `json:"login"`
-	Email string `json:"email"`
+	Id            int64  `json:"id"`
+	Login         string `json:"login"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 type GoogleUserInfo struct {
@@ -1,53 +1,292 @@
 package server
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/hashing"
 	"github.com/antlko/goauth-boilerplate/internal/jwt"
+	"github.com/antlko/goauth-boilerplate/internal/lockout"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"github.com/antlko/goauth-boilerplate/internal/password"
 	"github.com/antlko/goauth-boilerplate/internal/server/handlers"
 	"github.com/antlko/goauth-boilerplate/internal/server/middlewares"
+	"github.com/antlko/goauth-boilerplate/internal/verification"
 	"github.com/gofiber/fiber/v3"
 	"github.com/gofiber/fiber/v3/middleware/cors"
 	"github.com/jmoiron/sqlx"
 	"golang.org/x/oauth2"
+	"log/slog"
+	"slices"
+	"time"
 )
 
 type Config struct {
 	ServerPort        string `env:"SERVER_PORT"`
 	ClientCallbackURL string `env:"CLIENT_OAUTH2_CALLBACK_URL"`
-	JwtConfig         jwt.Config
+	// ClientDeviceVerificationURL is the client page where signed in users enter device flow user codes.
+	ClientDeviceVerificationURL string `env:"CLIENT_DEVICE_VERIFICATION_URL"`
+	// ClientInvitationURL is the client page where signed in users accept or decline organization invitations.
+	ClientInvitationURL string `env:"CLIENT_INVITATION_URL"`
+	// ClientPasswordResetURL is the client page where users set a new password from the reset link.
+	ClientPasswordResetURL string `env:"CLIENT_PASSWORD_RESET_URL"`
+	// ClientEmailVerificationURL is the client page verification links lead to.
+	ClientEmailVerificationURL string `env:"CLIENT_EMAIL_VERIFICATION_URL"`
+	// ClientAccountUnlockURL is the client page unlock links of locked accounts lead to.
+	ClientAccountUnlockURL string `env:"CLIENT_ACCOUNT_UNLOCK_URL"`
+	// AdminBootstrapLogin is given the admin role on start while no user has it, once the user verified the email.
+	AdminBootstrapLogin string `env:"ADMIN_BOOTSTRAP_LOGIN"`
+	// CorsAllowOrigins are the client origins, they must be listed explicitly in cookie mode.
+	CorsAllowOrigins []string `env:"CORS_ALLOW_ORIGINS, default=*"`
+	CookieConfig     middlewares.CookieConfig
+	JwtConfig        jwt.Config
+	HashingConfig    hashing.Config
+	PasswordConfig   password.Config
+	MailerConfig     mailer.Config
+	LockoutConfig    lockout.Config
 }
 
 func InitServer(cfg Config, dbInst *sqlx.DB, googleConfig *oauth2.Config) error {
+	// Browsers send cookies cross-origin only to credential-aware CORS, which can't allow any origin.
+	if cfg.CookieConfig.CookieMode && slices.Contains(cfg.CorsAllowOrigins, "*") {
+		return fmt.Errorf("cookie mode requires explicit CORS_ALLOW_ORIGINS")
+	}
+	passwordHasher, err := hashing.NewHasher(cfg.HashingConfig)
+	if err != nil {
+		return fmt.Errorf("password hasher initialisation: %w", err)
+	}
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordConfig, passwordHasher.MaxPasswordBytes())
+	if err != nil {
+		return fmt.Errorf("password policy initialisation: %w", err)
+	}
+	tokenCookies, err := middlewares.NewTokenCookies(
+		cfg.CookieConfig,
+		time.Hour*time.Duration(cfg.JwtConfig.JwtAccessTokenHours),
+		time.Hour*time.Duration(cfg.JwtConfig.JwtRefreshTokenHours),
+	)
+	if err != nil {
+		return fmt.Errorf("token cookies initialisation: %w", err)
+	}
+
 	app := fiber.New()
 	app.Use(cors.New(cors.Config{
-		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "Content-Length", "Access-Control-Allow-Origin", "Access-Control-Allow-Headers", "Accept-Language", "Content-Length", "Authorization"},
-		AllowOrigins: []string{"*", "Access-Control-Allow-Headers"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Content-Length", "Access-Control-Allow-Origin", "Access-Control-Allow-Headers", "Accept-Language", "Content-Length", "Authorization", middlewares.CsrfTokenHeader},
+		AllowOrigins:     cfg.CorsAllowOrigins,
+		AllowCredentials: cfg.CookieConfig.CookieMode,
 	}))
 
 	userRepo := db.NewUserRepo(dbInst)
-	authorizer := jwt.NewAuthorizer(cfg.JwtConfig)
+	refreshTokenRepo := db.NewRefreshTokenRepo(dbInst)
+	sessionRepo := db.NewSessionRepo(dbInst)
+	revokedTokenRepo := db.NewRevokedTokenRepo(dbInst)
+	personalAccessTokenRepo := db.NewPersonalAccessTokenRepo(dbInst)
+	roleRepo := db.NewRoleRepo(dbInst)
+	organizationRepo := db.NewOrganizationRepo(dbInst)
+	mailTransport, err := mailer.New(cfg.MailerConfig)
+	if err != nil {
+		return fmt.Errorf("mailer initialisation: %w", err)
+	}
+	mailTemplates, err := mailer.LoadTemplates(cfg.MailerConfig)
+	if err != nil {
+		return fmt.Errorf("mail templates initialisation: %w", err)
+	}
+	mailOutbox := mailer.NewOutbox(db.NewMailOutboxRepo(dbInst), mailTransport, cfg.MailerConfig.MailMaxAttempts)
+	go mailOutbox.Run(context.Background())
+	templateMailer := mailer.NewTemplateMailer(mailTemplates, mailOutbox)
+	authorizer, err := jwt.NewAuthorizer(
+		cfg.JwtConfig,
+		refreshTokenRepo,
+		sessionRepo,
+		revokedTokenRepo,
+		userRepo,
+		roleRepo,
+		organizationRepo,
+		personalAccessTokenRepo,
+	)
+	if err != nil {
+		return fmt.Errorf("authorizer initialisation: %w", err)
+	}
+
+	if cfg.AdminBootstrapLogin != "" {
+		if err := bootstrapAdmin(context.Background(), userRepo, roleRepo, cfg.AdminBootstrapLogin); err != nil {
+			return fmt.Errorf("admin bootstrap: %w", err)
+		}
+	}
+	bearerVerifier := middlewares.BearerVerifier(authorizer, tokenCookies)
 
-	authHandler := handlers.NewAuthHandler(userRepo, userRepo, authorizer, googleConfig, cfg.ClientCallbackURL)
+	emailVerifier := verification.NewEmailVerifier(
+		db.NewEmailVerificationRepo(dbInst),
+		templateMailer,
+		cfg.ClientEmailVerificationURL,
+	)
+	lockoutRepo := db.NewLockoutRepo(dbInst)
+	signInGuard := lockout.NewGuard(
+		cfg.LockoutConfig,
+		lockoutRepo,
+		db.NewSignInAttemptRepo(dbInst),
+		db.NewAccountUnlockRepo(dbInst),
+		templateMailer,
+		cfg.ClientAccountUnlockURL,
+	)
+	go signInGuard.Run(context.Background())
+	authHandler := handlers.NewAuthHandler(
+		userRepo,
+		userRepo,
+		userRepo,
+		passwordHasher,
+		passwordPolicy,
+		emailVerifier,
+		signInGuard,
+		authorizer,
+		googleConfig,
+		tokenCookies,
+		cfg.ClientCallbackURL,
+	)
+	passwordHandler := handlers.NewPasswordHandler(
+		userRepo,
+		userRepo,
+		db.NewPasswordResetRepo(dbInst),
+		userRepo,
+		passwordHasher,
+		passwordPolicy,
+		signInGuard,
+		authorizer,
+		templateMailer,
+		cfg.ClientPasswordResetURL,
+	)
+	emailVerificationHandler := handlers.NewEmailVerificationHandler(emailVerifier, userRepo)
 	userHandler := handlers.NewUserHandler(userRepo)
+	personalAccessTokenHandler := handlers.NewPersonalAccessTokenHandler(authorizer, personalAccessTokenRepo)
+	sessionHandler := handlers.NewSessionHandler(authorizer, sessionRepo)
+	adminHandler := handlers.NewAdminHandler(roleRepo, authorizer)
+	orgHandler := handlers.NewOrgHandler(
+		organizationRepo,
+		db.NewInvitationRepo(dbInst),
+		userRepo,
+		authorizer,
+		templateMailer,
+		tokenCookies,
+		cfg.ClientInvitationURL,
+	)
+	auditLogRepo := db.NewAuditLogRepo(dbInst)
+	impersonationHandler := handlers.NewImpersonationHandler(authorizer, auditLogRepo)
+	lockoutHandler := handlers.NewLockoutHandler(signInGuard, lockoutRepo, auditLogRepo)
+	wellKnownHandler := handlers.NewWellKnownHandler(authorizer, cfg.JwtConfig.JwtIssuer)
+	oauth2Handler := handlers.NewOAuth2Handler(
+		db.NewClientRepo(dbInst),
+		db.NewAuthorizationCodeRepo(dbInst),
+		db.NewDeviceCodeRepo(dbInst),
+		db.NewUserCodeAttemptRepo(dbInst),
+		userRepo,
+		passwordHasher,
+		signInGuard,
+		authorizer,
+		tokenCookies,
+		cfg.ClientDeviceVerificationURL,
+	)
 
 	app.Use(
 		middlewares.Logger,
 		middlewares.Error,
 	)
 
+	app.Get("/.well-known/jwks.json", wellKnownHandler.JWKS)
+	app.Get("/.well-known/openid-configuration", wellKnownHandler.OpenIDConfiguration)
+
 	app.Post("/api/v1/auth/signup", authHandler.SignUp)
 	app.Post("/api/v1/auth/signin", authHandler.SignIn)
 	app.Post("/api/v1/auth/token/refresh", authHandler.Verify)
+	app.Post("/api/v1/auth/logout", authHandler.Logout, bearerVerifier)
+	app.Post("/api/v1/auth/logout/all", authHandler.LogoutEverywhere, bearerVerifier, middlewares.RejectImpersonation)
+	app.Get("/api/v1/auth/csrf", authHandler.Csrf)
+	app.Post("/api/v1/auth/email/verify", emailVerificationHandler.Verify)
+	app.Post("/api/v1/auth/email/verify/resend", emailVerificationHandler.Resend, bearerVerifier, middlewares.RejectImpersonation)
+	app.Post("/api/v1/auth/password/forgot", passwordHandler.Forgot)
+	app.Post("/api/v1/auth/password/reset", passwordHandler.Reset)
+	app.Post("/api/v1/auth/unlock", lockoutHandler.Unlock)
 
 	app.Post("/api/v1/oauth2/google/signin", authHandler.GoogleSignIn)
 	app.Get("/api/v1/oauth2/google/callback", authHandler.GoogleCallback)
 
-	protected := app.Group("/api/v1/protected", middlewares.BearerVerifier(authorizer))
+	app.Get("/api/v1/oauth2/authorize", oauth2Handler.Authorize)
+	app.Post("/api/v1/oauth2/authorize", oauth2Handler.AuthorizeSubmit)
+	app.Post("/api/v1/oauth2/token", oauth2Handler.Token)
+	app.Post("/api/v1/oauth2/device/code", oauth2Handler.DeviceAuthorization)
+	app.Post("/api/v1/oauth2/introspect", oauth2Handler.Introspect)
+	app.Post("/api/v1/oauth2/revoke", oauth2Handler.Revoke)
+	app.Post("/api/v1/oauth2/token/exchange", impersonationHandler.Exchange,
+		bearerVerifier, middlewares.RequirePermission(db.PermissionUsersImpersonate))
+	requireOpenId := middlewares.RequireScope(jwt.ScopeOpenId)
+	app.Get("/api/v1/oauth2/userinfo", userHandler.UserInfo, bearerVerifier, requireOpenId)
+	app.Post("/api/v1/oauth2/userinfo", userHandler.UserInfo, bearerVerifier, requireOpenId)
+
+	protected := app.Group("/api/v1/protected", bearerVerifier, middlewares.RequireVerifiedEmail)
 	protected.Get("/user", userHandler.GetUser)
+	protected.Get("/device", oauth2Handler.Device)
+	protected.Post("/device", oauth2Handler.DeviceDecision, middlewares.RejectImpersonation)
+	protected.Get("/tokens", personalAccessTokenHandler.List)
+	protected.Post("/tokens", personalAccessTokenHandler.Create, middlewares.RejectImpersonation)
+	protected.Delete("/tokens/:id", personalAccessTokenHandler.Revoke, middlewares.RejectImpersonation)
+	protected.Post("/password", passwordHandler.Change, middlewares.RejectImpersonation)
+	protected.Get("/sessions", sessionHandler.List)
+	protected.Delete("/sessions/:id", sessionHandler.Revoke, middlewares.RejectImpersonation)
+	protected.Get("/orgs", orgHandler.List)
+	protected.Post("/orgs", orgHandler.Create)
+	protected.Post("/orgs/:org_id/switch", orgHandler.Switch, middlewares.RejectImpersonation)
+	protected.Post("/invitations/accept", orgHandler.AcceptInvitation, middlewares.RejectImpersonation)
+	protected.Post("/invitations/decline", orgHandler.DeclineInvitation, middlewares.RejectImpersonation)
+
+	org := app.Group("/api/v1/orgs/:org_id", bearerVerifier, middlewares.RequireOrg("org_id"))
+	org.Get("/members", orgHandler.Members)
+	org.Post("/invitations", orgHandler.Invite, middlewares.RequireOrgRole(db.OrgRoleOwner, db.OrgRoleAdmin))
+
+	// Group middlewares apply to every path under the prefix, the admin routes check their permission one by one.
+	admin := app.Group("/api/v1/admin", bearerVerifier)
+	requireRolesManage := middlewares.RequirePermission(db.PermissionRolesManage)
+	admin.Get("/roles", adminHandler.ListRoles, requireRolesManage)
+	admin.Get("/users/:id/roles", adminHandler.GetUserRoles, requireRolesManage)
+	admin.Put("/users/:id/roles/:role", adminHandler.AssignRole, requireRolesManage)
+	admin.Delete("/users/:id/roles/:role", adminHandler.UnassignRole, requireRolesManage)
+
+	lockouts := admin.Group("/lockouts", middlewares.RequirePermission(db.PermissionUsersUnlock))
+	lockouts.Get("", lockoutHandler.List)
+	lockouts.Delete("/:id", lockoutHandler.Reset)
 
 	if err := app.Listen(":" + cfg.ServerPort); err != nil {
 		return fmt.Errorf("server listen: %w", err)
 	}
 	return nil
 }
+
+// bootstrapAdmin gives the admin role to the existing user with the login once the user verified the email,
+// so nobody can claim the role by signing up with the configured login first. A missing or unverified
+// user is logged as an error and the app starts without an admin.
+func bootstrapAdmin(ctx context.Context, userRepo db.UserRepo, roleRepo db.RoleRepo, login string) error {
+	user, err := userRepo.GetByLogin(ctx, login)
+	// GetByLogin matches emails too, only the login itself is trusted.
+	if err == nil && user.Login != login {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, fmt.Sprintf("ADMIN_BOOTSTRAP_LOGIN user %q doesn't exist, no admin role assigned", login))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerifiedAt.Valid {
+		slog.ErrorContext(ctx, fmt.Sprintf("ADMIN_BOOTSTRAP_LOGIN user %q hasn't verified the email %s, no admin role assigned", login, user.Email))
+		return nil
+	}
+
+	assigned, err := roleRepo.BootstrapAdmin(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if assigned {
+		slog.WarnContext(ctx, fmt.Sprintf("admin role assigned to %s (%s)", login, user.Email))
+	}
+	return nil
+}
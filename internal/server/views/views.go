@@ -0,0 +1,25 @@
+package views
+
+import (
+	"embed"
+	"html/template"
+)
+
+//go:embed *.html
+var files embed.FS
+
+var Authorize = template.Must(template.ParseFS(files, "authorize.html"))
+
+// AuthorizeData renders the sign in form of the oauth2 authorize endpoint.
+// Error alone renders an error page without the form.
+type AuthorizeData struct {
+	ClientName string
+	Scopes     []string
+	Error      string
+	// Params are the authorize request parameters passed back as hidden fields.
+	Params map[string]string
+	// CsrfToken echoes the CSRF cookie in the form, AuthorizeSubmit rejects posts without it.
+	CsrfToken string
+	// ShowForm is false for errors which can't be redirected back to the client.
+	ShowForm bool
+}
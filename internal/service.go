@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"github.com/antlko/goauth-boilerplate/internal/db"
 	"github.com/antlko/goauth-boilerplate/internal/logger"
 	oauthgoogle "github.com/antlko/goauth-boilerplate/internal/oauth2/google"
@@ -28,13 +29,13 @@ func InitService(cfg AppConfig) {
 
 	dbInst, err := db.NewDB(cfg.DB, cfg.ApplicationName)
 	if err != nil {
-		slog.ErrorContext(ctx, "db initialisation: %s", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("db initialisation: %s", err.Error()))
 		return
 	}
 
 	googleConfig := oauthgoogle.InitConfig(cfg.GoogleOauth2)
 
 	if err := server.InitServer(cfg.Server, dbInst, googleConfig); err != nil {
-		slog.ErrorContext(ctx, "server initialisation: %s", err.Error())
+		slog.ErrorContext(ctx, fmt.Sprintf("server initialisation: %s", err.Error()))
 	}
 }
@@ -0,0 +1,95 @@
+package verification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/antlko/goauth-boilerplate/internal/db"
+	"github.com/antlko/goauth-boilerplate/internal/mailer"
+	"github.com/antlko/goauth-boilerplate/internal/opaque"
+	"net/url"
+	"time"
+)
+
+const (
+	verificationDuration   = 24 * time.Hour
+	tokenQueryParam        = "token"
+	resendInterval         = time.Minute
+	resendWindow           = time.Hour
+	maxVerificationsWindow = 5
+)
+
+var ErrThrottled = errors.New("verification email throttled")
+
+type emailSender interface {
+	SendTemplate(ctx context.Context, email mailer.Email) error
+}
+
+type store interface {
+	Insert(ctx context.Context, verification db.EmailVerification) error
+	ListSentSince(ctx context.Context, userId int64, since time.Time) ([]time.Time, error)
+	Use(ctx context.Context, hash string) (int64, error)
+}
+
+// EmailVerifier emails verification links, a user gets one link a minute and at most 5 an hour.
+type EmailVerifier struct {
+	store       store
+	emailSender emailSender
+	// verificationURL is the client page links lead to, the token is added as a query parameter.
+	verificationURL string
+}
+
+func NewEmailVerifier(store store, emailSender emailSender, verificationURL string) EmailVerifier {
+	return EmailVerifier{
+		store:           store,
+		emailSender:     emailSender,
+		verificationURL: verificationURL,
+	}
+}
+
+// Send emails a verification link for the current email of the user in the locale best matching the
+// Accept-Language value. ErrThrottled is returned with the time to wait when the user had too many links sent lately.
+func (v EmailVerifier) Send(ctx context.Context, user db.User, locale string) (time.Duration, error) {
+	now := time.Now()
+	sent, err := v.store.ListSentSince(ctx, user.Id, now.Add(-resendWindow))
+	if err != nil {
+		return 0, err
+	}
+	if len(sent) > 0 {
+		if wait := sent[len(sent)-1].Add(resendInterval).Sub(now); wait > 0 {
+			return wait, ErrThrottled
+		}
+	}
+	if len(sent) >= maxVerificationsWindow {
+		return sent[len(sent)-maxVerificationsWindow].Add(resendWindow).Sub(now), ErrThrottled
+	}
+
+	token, err := opaque.Generate()
+	if err != nil {
+		return 0, err
+	}
+	if err := v.store.Insert(ctx, db.EmailVerification{
+		UserId:    user.Id,
+		Email:     user.Email,
+		TokenHash: opaque.Hash(token),
+		ExpiresAt: now.Add(verificationDuration),
+	}); err != nil {
+		return 0, err
+	}
+
+	link := v.verificationURL + "?" + url.Values{tokenQueryParam: {token}}.Encode()
+	if err := v.emailSender.SendTemplate(ctx, mailer.Email{
+		To:       user.Email,
+		Template: mailer.TemplateEmailVerification,
+		Locale:   locale,
+		Data:     mailer.LinkData{Login: user.Login, Link: link},
+	}); err != nil {
+		return 0, fmt.Errorf("send email verification: %w", err)
+	}
+	return 0, nil
+}
+
+// Verify marks the email of the link verified and returns the user id, the link works once.
+func (v EmailVerifier) Verify(ctx context.Context, token string) (int64, error) {
+	return v.store.Use(ctx, opaque.Hash(token))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/antlko/goauth-boilerplate/internal"
 	"github.com/joho/godotenv"
 	"github.com/sethvargo/go-envconfig"
@@ -17,7 +18,7 @@ func main() {
 	ctx := context.Background()
 
 	if err := envconfig.Process(ctx, &cfg); err != nil {
-		slog.Error("process config: %s", err)
+		slog.Error(fmt.Sprintf("process config: %s", err))
 		return
 	}
 	internal.InitService(cfg)